@@ -0,0 +1,128 @@
+// Package broker carries WebSocket hub events between server instances so a
+// user connected to any instance receives events published on every other
+package broker
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+)
+
+// Event types
+const (
+	EventDeliver      = "deliver"       // Data goes to every device of UserIDs but ExceptDevice
+	EventPresence     = "presence"      // UserIDs came online or went offline on Node
+	EventPresenceSync = "presence_sync" // UserIDs is the full set of users online on Node
+)
+
+// Event is one message carried by a broker
+type Event struct {
+	Type    string          `json:"type"`
+	Node    string          `json:"node"` // Instance that published the event
+	UserIDs []int64         `json:"user_ids,omitempty"`
+	Online  bool            `json:"online,omitempty"`
+	Seq     int64           `json:"seq,omitempty"` // Event log position of Data, for a single recipient
+	Data    json.RawMessage `json:"data,omitempty"`
+	// ExceptDevice skips the single recipient's device that already has Data
+	ExceptDevice string `json:"except_device,omitempty"`
+}
+
+// Broker fans events out to every instance, including the publishing one
+type Broker interface {
+	// Publish sends an event to the subscribers of every instance
+	Publish(event Event) error
+	// Subscribe registers a handler for every event received by this instance.
+	// Handlers run on a single goroutine, in the order events arrive.
+	Subscribe(handler func(Event))
+	Close() error
+}
+
+// NewFromEnv builds the broker selected by BROKER: "memory" (default) for a
+// single instance, or "postgres" to share events through LISTEN/NOTIFY on
+// BROKER_URL (defaults to DATABASE_URL)
+func NewFromEnv() (Broker, error) {
+	switch kind := os.Getenv("BROKER"); kind {
+	case "", "memory":
+		return NewMemory(), nil
+	case "postgres":
+		url := os.Getenv("BROKER_URL")
+		if url == "" {
+			url = os.Getenv("DATABASE_URL")
+		}
+		if url == "" {
+			return nil, fmt.Errorf("BROKER=postgres requires BROKER_URL or DATABASE_URL")
+		}
+		log.Println("Using Postgres LISTEN/NOTIFY broker")
+		return NewPostgres(url)
+	default:
+		return nil, fmt.Errorf("unknown BROKER %q (expected memory or postgres)", kind)
+	}
+}
+
+// subscribers holds registered handlers and calls them in order
+type subscribers struct {
+	mu       sync.RWMutex
+	handlers []func(Event)
+}
+
+// Subscribe registers a handler for every event received by this instance
+func (s *subscribers) Subscribe(handler func(Event)) {
+	s.mu.Lock()
+	s.handlers = append(s.handlers, handler)
+	s.mu.Unlock()
+}
+
+func (s *subscribers) dispatch(event Event) {
+	s.mu.RLock()
+	handlers := s.handlers
+	s.mu.RUnlock()
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
+
+// Memory is an in-process broker for single-instance deployments
+type Memory struct {
+	subscribers
+	events chan Event
+	done   chan struct{}
+}
+
+// NewMemory starts an in-process broker
+func NewMemory() *Memory {
+	m := &Memory{
+		events: make(chan Event, 1024),
+		done:   make(chan struct{}),
+	}
+	go m.run()
+	return m
+}
+
+// Publish queues an event for local subscribers
+func (m *Memory) Publish(event Event) error {
+	select {
+	case m.events <- event:
+		return nil
+	case <-m.done:
+		return fmt.Errorf("broker closed")
+	}
+}
+
+// Close stops delivering events
+func (m *Memory) Close() error {
+	close(m.done)
+	return nil
+}
+
+func (m *Memory) run() {
+	for {
+		select {
+		case event := <-m.events:
+			m.dispatch(event)
+		case <-m.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package broker
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// notifyChannel is the LISTEN/NOTIFY channel shared by every instance
+const notifyChannel = "scuffedsnap_events"
+
+// maxNotifyPayload stays under Postgres' 8000 byte NOTIFY limit; larger
+// events are split into chunks sent in one transaction
+const maxNotifyPayload = 7800
+
+// maxPendingChunked bounds partially received events kept for reassembly
+const maxPendingChunked = 256
+
+// Postgres shares events between instances through LISTEN/NOTIFY
+type Postgres struct {
+	subscribers
+	db       *sql.DB
+	listener *pq.Listener
+	pending  map[string]*chunkedEvent // Only touched by the run goroutine
+}
+
+// chunkedEvent collects the parts of an event larger than one NOTIFY
+type chunkedEvent struct {
+	parts [][]byte
+	got   int
+}
+
+// NewPostgres connects to url, listens for events and starts dispatching them
+func NewPostgres(url string) (*Postgres, error) {
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, err
+	}
+	// One publishing connection keeps events in the order they were sent
+	db.SetMaxOpenConns(1)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	listener := pq.NewListener(url, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Broker listener: %v", err)
+		}
+	})
+	if err := listener.Listen(notifyChannel); err != nil {
+		listener.Close()
+		db.Close()
+		return nil, err
+	}
+
+	p := &Postgres{
+		db:       db,
+		listener: listener,
+		pending:  make(map[string]*chunkedEvent),
+	}
+	go p.run()
+	return p, nil
+}
+
+// Publish sends an event to every listening instance
+func (p *Postgres) Publish(event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if len(data) <= maxNotifyPayload {
+		_, err := p.db.Exec(`SELECT pg_notify($1, $2)`, notifyChannel, string(data))
+		return err
+	}
+
+	// Notifications from one transaction are delivered together and in order
+	chunks := splitPayload(data, maxNotifyPayload-64)
+	id := uuid.NewString()
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for i, chunk := range chunks {
+		payload := fmt.Sprintf("~%s:%d:%d:%s", id, i, len(chunks), chunk)
+		if _, err := tx.Exec(`SELECT pg_notify($1, $2)`, notifyChannel, payload); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Close stops listening and closes the publishing connection
+func (p *Postgres) Close() error {
+	err := p.listener.Close()
+	if dbErr := p.db.Close(); err == nil {
+		err = dbErr
+	}
+	return err
+}
+
+func (p *Postgres) run() {
+	keepalive := time.NewTicker(90 * time.Second)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case n, ok := <-p.listener.Notify:
+			if !ok {
+				return
+			}
+			// A nil notification means the connection was re-established;
+			// chunks sent while it was down are gone
+			if n == nil {
+				p.pending = make(map[string]*chunkedEvent)
+				continue
+			}
+			data := p.assemble(n.Extra)
+			if data == nil {
+				continue
+			}
+			var event Event
+			if err := json.Unmarshal(data, &event); err != nil {
+				log.Printf("Broker: dropping malformed event: %v", err)
+				continue
+			}
+			p.dispatch(event)
+
+		case <-keepalive.C:
+			go p.listener.Ping()
+		}
+	}
+}
+
+// assemble returns a complete event payload, or nil while chunks are missing
+func (p *Postgres) assemble(payload string) []byte {
+	if !strings.HasPrefix(payload, "~") {
+		return []byte(payload)
+	}
+
+	// ~<id>:<index>:<count>:<chunk>
+	fields := strings.SplitN(payload[1:], ":", 4)
+	if len(fields) != 4 {
+		return nil
+	}
+	index, err1 := strconv.Atoi(fields[1])
+	count, err2 := strconv.Atoi(fields[2])
+	if err1 != nil || err2 != nil || count <= 0 || index < 0 || index >= count {
+		return nil
+	}
+
+	chunked := p.pending[fields[0]]
+	if chunked == nil {
+		if len(p.pending) >= maxPendingChunked {
+			p.pending = make(map[string]*chunkedEvent)
+		}
+		chunked = &chunkedEvent{parts: make([][]byte, count)}
+		p.pending[fields[0]] = chunked
+	}
+	if index >= len(chunked.parts) || chunked.parts[index] != nil {
+		return nil
+	}
+	chunked.parts[index] = []byte(fields[3])
+	chunked.got++
+	if chunked.got < len(chunked.parts) {
+		return nil
+	}
+	delete(p.pending, fields[0])
+	return bytes.Join(chunked.parts, nil)
+}
+
+// splitPayload cuts data into chunks of at most size bytes without
+// splitting a UTF-8 sequence, since NOTIFY payloads must be valid text
+func splitPayload(data []byte, size int) [][]byte {
+	var chunks [][]byte
+	for len(data) > size {
+		cut := size
+		for cut > 0 && !utf8.RuneStart(data[cut]) {
+			cut--
+		}
+		chunks = append(chunks, data[:cut])
+		data = data[cut:]
+	}
+	return append(chunks, data)
+}
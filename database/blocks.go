@@ -0,0 +1,108 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+
+	"scuffedsnap/models"
+)
+
+// ErrBlocked is returned when an action is refused because one of the two
+// users has blocked the other
+var ErrBlocked = errors.New("database: users have blocked each other")
+
+// blockedBetween matches a block in either direction between two users,
+// taking the pair of user IDs twice
+const blockedBetween = `EXISTS (SELECT 1 FROM friends b
+	WHERE b.status = 'blocked'
+	  AND ((b.user_id = ? AND b.friend_id = ?) OR (b.user_id = ? AND b.friend_id = ?)))`
+
+// rowQuerier is satisfied by *sqlStore and *sqlTx
+type rowQuerier interface {
+	queryRow(query string, args ...interface{}) *sql.Row
+}
+
+// checkNotBlocked returns ErrBlocked if either user has blocked the other
+func checkNotBlocked(q rowQuerier, userID1, userID2 int64) error {
+	var blocked bool
+	if err := q.queryRow("SELECT "+blockedBetween, userID1, userID2, userID2, userID1).Scan(&blocked); err != nil {
+		return err
+	}
+	if blocked {
+		return ErrBlocked
+	}
+	return nil
+}
+
+// Block queries
+
+// BlockUser blocks blockedID for blockerID, dropping any friendship or
+// pending request between them
+func (s *sqlStore) BlockUser(blockerID, blockedID int64) error {
+	return s.inTx(func(tx *sqlTx) error {
+		if _, err := tx.exec(
+			`DELETE FROM friends
+			WHERE ((user_id = ? AND friend_id = ?) OR (user_id = ? AND friend_id = ?))
+			  AND (status != 'blocked' OR user_id = ?)`,
+			blockerID, blockedID, blockedID, blockerID, blockerID,
+		); err != nil {
+			return err
+		}
+		_, err := tx.exec(
+			"INSERT INTO friends (user_id, friend_id, status, created_at) VALUES (?, ?, 'blocked', ?)",
+			blockerID, blockedID, now(),
+		)
+		return err
+	})
+}
+
+// UnblockUser lifts a block that blockerID placed on blockedID
+func (s *sqlStore) UnblockUser(blockerID, blockedID int64) error {
+	result, err := s.exec(
+		"DELETE FROM friends WHERE user_id = ? AND friend_id = ? AND status = 'blocked'",
+		blockerID, blockedID,
+	)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetBlockedUsers lists the users a user has blocked
+func (s *sqlStore) GetBlockedUsers(userID int64) ([]models.UserResponse, error) {
+	rows, err := s.query(
+		`SELECT u.id, u.username, COALESCE(u.avatar, ''), u.created_at
+		FROM friends f
+		JOIN users u ON u.id = f.friend_id
+		WHERE f.user_id = ? AND f.status = 'blocked'
+		ORDER BY f.created_at DESC`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.UserResponse
+	for rows.Next() {
+		var user models.UserResponse
+		if err := rows.Scan(&user.ID, &user.Username, &user.Avatar, &user.CreatedAt); err != nil {
+			return nil, err
+		}
+		user.Avatar = models.AvatarURL(user.ID, user.Avatar)
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// IsBlocked reports whether either user has blocked the other
+func (s *sqlStore) IsBlocked(userID1, userID2 int64) (bool, error) {
+	err := checkNotBlocked(s, userID1, userID2)
+	if errors.Is(err, ErrBlocked) {
+		return true, nil
+	}
+	return false, err
+}
@@ -0,0 +1,28 @@
+package database
+
+import "testing"
+
+func TestDeleteFriendKeepsBlocks(t *testing.T) {
+	s := newInboxStore(t, 2, 0, 0)
+	if err := s.BlockUser(1, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	// Neither the blocked user nor the blocker removing a "friend" lifts it
+	for _, pair := range [][2]int64{{2, 1}, {1, 2}} {
+		if err := s.DeleteFriend(pair[0], pair[1]); err != nil {
+			t.Fatal(err)
+		}
+		blocked, err := s.IsBlocked(1, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !blocked {
+			t.Fatalf("user %d removing %d as a friend lifted the block", pair[0], pair[1])
+		}
+	}
+
+	if err := s.UnblockUser(1, 2); err != nil {
+		t.Fatal(err)
+	}
+}
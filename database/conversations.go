@@ -0,0 +1,140 @@
+package database
+
+import (
+	"strings"
+
+	"scuffedsnap/models"
+)
+
+// Conversation queries
+
+// inboxMessageColumns selects messageColumns through the inbox's LEFT JOIN on
+// messages, with placeholders in the NOT NULL columns for groups that have no
+// messages yet. Those rows scan with ID 0. Timestamps stay bare columns, since
+// SQLite only returns them as times while their declared type is visible.
+var inboxMessageColumns = strings.NewReplacer(
+	"m.id,", "COALESCE(m.id, 0),",
+	"m.sender_id,", "COALESCE(m.sender_id, 0),",
+	"m.content,", "COALESCE(m.content, ''),",
+	"m.type,", "COALESCE(m.type, ''),",
+).Replace(messageColumns)
+
+// inboxCTE lists each of a user's direct partners and groups with its latest
+// unexpired message and the user's unread count. Rows are keyed by
+// (activity, sort_id), where sort_id is the last message ID, or minus the
+// group ID for a group with no messages, so the order is total.
+const inboxCTE = `
+WITH direct_messages AS (
+	SELECT m.id, m.created_at, m.receiver_id, m.read_at, m.receiver_id AS partner_id
+	FROM messages m
+	WHERE m.sender_id = ? AND m.receiver_id IS NOT NULL
+	  AND (m.expires_at IS NULL OR m.expires_at > ?)
+	UNION ALL
+	SELECT m.id, m.created_at, m.receiver_id, m.read_at, m.sender_id
+	FROM messages m
+	WHERE m.receiver_id = ? AND m.sender_id != ?
+	  AND (m.expires_at IS NULL OR m.expires_at > ?)
+),
+direct_threads AS (
+	SELECT partner_id, id AS last_id, created_at AS activity, unread
+	FROM (
+		SELECT partner_id, id, created_at,
+		       ROW_NUMBER() OVER (PARTITION BY partner_id ORDER BY created_at DESC, id DESC) AS rn,
+		       SUM(CASE WHEN receiver_id = ? AND read_at IS NULL THEN 1 ELSE 0 END) OVER (PARTITION BY partner_id) AS unread
+		FROM direct_messages
+	) ranked
+	WHERE rn = 1
+),
+group_threads AS (
+	SELECT ranked.group_id, ranked.id AS last_id, COALESCE(ranked.created_at, c.created_at) AS activity, ranked.unread
+	FROM (
+		SELECT cm.conversation_id AS group_id, m.id, m.created_at,
+		       ROW_NUMBER() OVER (PARTITION BY cm.conversation_id ORDER BY m.created_at DESC, m.id DESC) AS rn,
+		       SUM(CASE WHEN m.sender_id != ? AND m.id > cm.last_read_message_id THEN 1 ELSE 0 END) OVER (PARTITION BY cm.conversation_id) AS unread
+		FROM conversation_members cm
+		LEFT JOIN messages m ON m.conversation_id = cm.conversation_id
+		  AND (m.expires_at IS NULL OR m.expires_at > ?)
+		WHERE cm.user_id = ?
+	) ranked
+	JOIN conversations c ON c.id = ranked.group_id
+	WHERE ranked.rn = 1
+),
+inbox AS (
+	SELECT 'direct' AS kind, partner_id AS ref_id, last_id, activity, unread, last_id AS sort_id FROM direct_threads
+	UNION ALL
+	SELECT 'group', group_id, last_id, activity, unread, COALESCE(last_id, -group_id) FROM group_threads
+)`
+
+// GetConversations retrieves a page of a user's direct and group
+// conversations, most recently active first, with one query. It reports
+// whether older conversations follow; page.After is not supported.
+func (s *sqlStore) GetConversations(userID int64, page models.Page) ([]models.Conversation, bool, error) {
+	cutoff := now()
+	args := []interface{}{userID, cutoff, userID, userID, cutoff, userID, userID, cutoff, userID}
+
+	before := ""
+	if page.Before != nil {
+		before = "AND (i.activity, i.sort_id) < (?, ?)"
+		args = append(args, s.dialect.timestamp(page.Before.Time), page.Before.ID)
+	}
+	// One extra row tells whether there is more
+	args = append(args, page.Limit+1)
+
+	rows, err := s.query(
+		inboxCTE+`
+		SELECT `+inboxMessageColumns+`, i.kind, i.unread,
+		       COALESCE(u.id, 0), COALESCE(u.username, ''), COALESCE(u.email, ''), COALESCE(u.avatar, ''),
+		       u.created_at, COALESCE(u.auth_method, 'email'), COALESCE(u.is_disabled, FALSE),
+		       COALESCE(c.id, 0), COALESCE(c.name, ''), COALESCE(c.avatar, ''), COALESCE(c.created_by, 0),
+		       c.created_at
+		FROM inbox i
+		LEFT JOIN users u ON i.kind = 'direct' AND u.id = i.ref_id
+		LEFT JOIN conversations c ON i.kind = 'group' AND c.id = i.ref_id
+		LEFT JOIN messages m ON m.id = i.last_id
+		WHERE (i.kind = 'group' OR u.id IS NOT NULL) `+before+`
+		ORDER BY i.activity DESC, i.sort_id DESC
+		LIMIT ?`,
+		args...,
+	)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	var conversations []models.Conversation
+	for rows.Next() {
+		var (
+			conv  models.Conversation
+			msg   models.Message
+			user  models.User
+			group models.Group
+		)
+		if err := scanMessage(rows, &msg,
+			&conv.Type, &conv.UnreadCount,
+			&user.ID, &user.Username, &user.Email, &user.Avatar, optionalTime{&user.CreatedAt}, &user.AuthMethod, &user.IsDisabled,
+			&group.ID, &group.Name, &group.Avatar, &group.CreatedBy, optionalTime{&group.CreatedAt},
+		); err != nil {
+			return nil, false, err
+		}
+
+		if conv.Type == models.ConversationDirect {
+			resp := user.ToResponse()
+			conv.User = &resp
+		} else {
+			conv.Group = &group
+		}
+		if msg.ID != 0 {
+			conv.LastMessage = &msg
+		}
+		conversations = append(conversations, conv)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	hasMore := len(conversations) > page.Limit
+	if hasMore {
+		conversations = conversations[:page.Limit]
+	}
+	return conversations, hasMore, nil
+}
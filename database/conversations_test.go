@@ -0,0 +1,128 @@
+package database
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"scuffedsnap/models"
+)
+
+// newInboxStore opens a migrated SQLite store in which user 1 has a direct
+// conversation with each of partners other users and belongs to groups
+// groups, every conversation holding messagesEach messages
+func newInboxStore(tb testing.TB, partners, groups, messagesEach int) *SQLiteStore {
+	tb.Helper()
+	s, err := NewSQLiteStore(filepath.Join(tb.TempDir(), "inbox.db"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { s.Close() })
+
+	// Keep migration logging out of benchmark output
+	log.SetOutput(io.Discard)
+	tb.Cleanup(func() { log.SetOutput(os.Stderr) })
+	SetStore(s)
+	if _, err := MigrateUp(); err != nil {
+		tb.Fatal(err)
+	}
+
+	err = s.inTx(func(tx *sqlTx) error {
+		for i := 1; i <= partners+1; i++ {
+			if _, err := tx.exec(
+				"INSERT INTO users (id, username, email, password) VALUES (?, ?, ?, 'x')",
+				i, fmt.Sprintf("user%d", i), fmt.Sprintf("user%d@example.com", i),
+			); err != nil {
+				return err
+			}
+		}
+		for partner := 2; partner <= partners+1; partner++ {
+			for n := 0; n < messagesEach; n++ {
+				sender, receiver := 1, partner
+				if n%2 == 1 {
+					sender, receiver = partner, 1
+				}
+				if _, err := tx.exec(
+					"INSERT INTO messages (sender_id, receiver_id, content) VALUES (?, ?, 'hello')",
+					sender, receiver,
+				); err != nil {
+					return err
+				}
+			}
+		}
+		for g := 1; g <= groups; g++ {
+			if _, err := tx.exec("INSERT INTO conversations (id, name, created_by) VALUES (?, ?, 1)", g, fmt.Sprintf("group%d", g)); err != nil {
+				return err
+			}
+			if _, err := tx.exec("INSERT INTO conversation_members (conversation_id, user_id, role) VALUES (?, 1, 'owner')", g); err != nil {
+				return err
+			}
+			for n := 0; n < messagesEach; n++ {
+				if _, err := tx.exec(
+					"INSERT INTO messages (sender_id, conversation_id, content) VALUES (1, ?, 'hello')", g,
+				); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return s
+}
+
+func TestGetConversationsPagesThroughInbox(t *testing.T) {
+	s := newInboxStore(t, 30, 10, 3)
+
+	seen := make(map[string]bool)
+	page := models.Page{Limit: 7}
+	var last *models.Conversation
+	for {
+		conversations, hasMore, err := s.GetConversations(1, page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range conversations {
+			conv := &conversations[i]
+			key := fmt.Sprintf("%s/%d", conv.Type, conv.Cursor().ID)
+			if seen[key] {
+				t.Fatalf("%s returned twice", key)
+			}
+			seen[key] = true
+			if last != nil && conv.LastActivity().After(last.LastActivity()) {
+				t.Fatalf("%s is newer than the conversation before it", key)
+			}
+			if conv.LastMessage == nil {
+				t.Fatalf("%s has no last message", key)
+			}
+			last = conv
+		}
+		if !hasMore {
+			break
+		}
+		cursor := last.Cursor()
+		page.Before = &cursor
+	}
+	if len(seen) != 40 {
+		t.Fatalf("paged through %d conversations, want 40", len(seen))
+	}
+}
+
+func BenchmarkGetConversations(b *testing.B) {
+	for _, partners := range []int{100, 1000, 5000} {
+		b.Run(fmt.Sprintf("conversations=%d", partners), func(b *testing.B) {
+			s := newInboxStore(b, partners, partners/10, 5)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := s.GetConversations(1, models.Page{Limit: 50}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
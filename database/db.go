@@ -1,103 +1,134 @@
 package database
 
 import (
-	"database/sql"
+	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
-	_ "github.com/lib/pq"
 	"scuffedsnap/models"
 )
 
-var DB *sql.DB
+// store is the active storage backend, set by Open
+var store Store
 
-// Initialize sets up the database connection and creates tables
-func Initialize() error {
-	databaseURL := os.Getenv("DATABASE_URL")
-	if databaseURL == "" {
-		log.Fatal("DATABASE_URL environment variable is required")
+// SQLite files used when DATABASE_URL is unset. Installs from before the
+// data directory keep their database where it is.
+const (
+	sqliteDefaultPath = "data/scuffedsnap.db"
+	sqliteLegacyPath  = "scuffedsnap.db"
+)
+
+// defaultSQLitePath picks the SQLite file to use when none is configured
+func defaultSQLitePath() string {
+	if _, err := os.Stat(sqliteDefaultPath); err == nil {
+		return sqliteDefaultPath
 	}
+	if _, err := os.Stat(sqliteLegacyPath); err == nil {
+		log.Printf("Using %s from an older install; move it to %s or set DATABASE_URL", sqliteLegacyPath, sqliteDefaultPath)
+		return sqliteLegacyPath
+	}
+	return sqliteDefaultPath
+}
 
-	var err error
-	DB, err = sql.Open("postgres", databaseURL)
-	if err != nil {
-		return err
+// Open connects to the configured storage backend without touching the schema.
+//
+// DATABASE_DRIVER selects "sqlite" or "postgres". When it is unset the driver
+// is inferred from DATABASE_URL: postgres:// URLs use Postgres, anything else
+// is treated as a SQLite file path (default "data/scuffedsnap.db").
+func Open() error {
+	databaseURL := os.Getenv("DATABASE_URL")
+
+	driver := os.Getenv("DATABASE_DRIVER")
+	if driver == "" {
+		if strings.HasPrefix(databaseURL, "postgres://") || strings.HasPrefix(databaseURL, "postgresql://") {
+			driver = "postgres"
+		} else {
+			driver = "sqlite"
+		}
 	}
 
-	// Test connection
-	if err := DB.Ping(); err != nil {
-		return err
+	switch driver {
+	case "postgres":
+		if databaseURL == "" {
+			log.Fatal("DATABASE_URL environment variable is required for postgres")
+		}
+		pg, err := NewPostgresStore(databaseURL)
+		if err != nil {
+			return err
+		}
+		store = pg
+	case "sqlite":
+		if databaseURL == "" {
+			databaseURL = defaultSQLitePath()
+		}
+		if err := os.MkdirAll(filepath.Dir(databaseURL), 0o750); err != nil {
+			return err
+		}
+		lite, err := NewSQLiteStore(databaseURL)
+		if err != nil {
+			return err
+		}
+		store = lite
+	default:
+		return fmt.Errorf("unknown DATABASE_DRIVER %q (expected sqlite or postgres)", driver)
 	}
 
-	// Set connection pool settings
-	DB.SetMaxOpenConns(25)
-	DB.SetMaxIdleConns(5)
-	DB.SetConnMaxLifetime(5 * time.Minute)
+	log.Printf("Connected to %s database", driver)
+	return nil
+}
 
-	// Create tables
-	if err := createTables(); err != nil {
+// Initialize opens the storage backend and applies pending migrations.
+// Set AUTO_MIGRATE=false to skip migrations and run them on demand instead.
+func Initialize() error {
+	if err := Open(); err != nil {
 		return err
 	}
 
+	if os.Getenv("AUTO_MIGRATE") != "false" {
+		applied, err := MigrateUp()
+		if err != nil {
+			return err
+		}
+		if applied > 0 {
+			log.Printf("Applied %d migration(s)", applied)
+		}
+	}
+
 	log.Println("Database initialized successfully")
 	return nil
 }
 
-func createTables() error {
-	tables := `
-	CREATE TABLE IF NOT EXISTS users (
-		id BIGSERIAL PRIMARY KEY,
-		username TEXT UNIQUE NOT NULL,
-		email TEXT UNIQUE NOT NULL,
-		password TEXT NOT NULL,
-		avatar TEXT DEFAULT '',
-		auth_method TEXT DEFAULT 'email',
-		is_disabled BOOLEAN DEFAULT FALSE,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-	);
-
-	CREATE TABLE IF NOT EXISTS sessions (
-		id TEXT PRIMARY KEY,
-		user_id BIGINT NOT NULL,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		expires_at TIMESTAMP NOT NULL,
-		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
-	);
-
-	CREATE TABLE IF NOT EXISTS messages (
-		id BIGSERIAL PRIMARY KEY,
-		sender_id BIGINT NOT NULL,
-		receiver_id BIGINT NOT NULL,
-		content TEXT NOT NULL,
-		type TEXT DEFAULT 'text',
-		expires_at TIMESTAMP,
-		read_at TIMESTAMP,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (sender_id) REFERENCES users(id) ON DELETE CASCADE,
-		FOREIGN KEY (receiver_id) REFERENCES users(id) ON DELETE CASCADE
-	);
-
-	CREATE TABLE IF NOT EXISTS friends (
-		id BIGSERIAL PRIMARY KEY,
-		user_id BIGINT NOT NULL,
-		friend_id BIGINT NOT NULL,
-		status TEXT DEFAULT 'pending',
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
-		FOREIGN KEY (friend_id) REFERENCES users(id) ON DELETE CASCADE,
-		UNIQUE(user_id, friend_id)
-	);
-
-	CREATE INDEX IF NOT EXISTS idx_messages_sender ON messages(sender_id);
-	CREATE INDEX IF NOT EXISTS idx_messages_receiver ON messages(receiver_id);
-	CREATE INDEX IF NOT EXISTS idx_friends_user ON friends(user_id);
-	CREATE INDEX IF NOT EXISTS idx_friends_friend ON friends(friend_id);
-	CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id);
-	`
-
-	_, err := DB.Exec(tables)
-	return err
+// SetStore replaces the active storage backend
+func SetStore(s Store) {
+	store = s
+}
+
+// Close closes the active storage backend
+func Close() error {
+	if store == nil {
+		return nil
+	}
+	return store.Close()
+}
+
+// Schema migrations
+
+// MigrateUp applies every pending migration and returns how many ran
+func MigrateUp() (int, error) {
+	return store.MigrateUp()
+}
+
+// MigrateDown rolls back the most recent steps migrations
+func MigrateDown(steps int) (int, error) {
+	return store.MigrateDown(steps)
+}
+
+// GetMigrationStatus lists every known migration and whether it has been applied
+func GetMigrationStatus() ([]MigrationStatus, error) {
+	return store.MigrationStatus()
 }
 
 // User queries
@@ -109,434 +140,445 @@ func CreateUser(username, email, password string) (*models.User, error) {
 
 // CreateUserWithAuth inserts a new user with auth method tracking
 func CreateUserWithAuth(username, email, password, authMethod string) (*models.User, error) {
-	result, err := DB.Exec(
-		"INSERT INTO users (username, email, password, auth_method) VALUES (?, ?, ?, ?)",
-		username, email, password, authMethod,
-	)
-	if err != nil {
-		return nil, err
-	}
-
-	id, err := result.LastInsertId()
-	if err != nil {
-		return nil, err
-	}
-
-	return GetUserByID(id)
+	return store.CreateUserWithAuth(username, email, password, authMethod)
 }
 
 // GetUserByID retrieves a user by their ID
 func GetUserByID(id int64) (*models.User, error) {
-	user := &models.User{}
-	err := DB.QueryRow(
-		"SELECT id, username, email, password, avatar, created_at, COALESCE(auth_method, 'email'), COALESCE(is_disabled, 0) FROM users WHERE id = ?",
-		id,
-	).Scan(&user.ID, &user.Username, &user.Email, &user.Password, &user.Avatar, &user.CreatedAt, &user.AuthMethod, &user.IsDisabled)
-	if err != nil {
-		return nil, err
-	}
-	return user, nil
+	return store.GetUserByID(id)
 }
 
 // GetUserByUsername retrieves a user by their username
 func GetUserByUsername(username string) (*models.User, error) {
-	user := &models.User{}
-	err := DB.QueryRow(
-		"SELECT id, username, email, password, avatar, created_at, COALESCE(auth_method, 'email'), COALESCE(is_disabled, 0) FROM users WHERE username = ?",
-		username,
-	).Scan(&user.ID, &user.Username, &user.Email, &user.Password, &user.Avatar, &user.CreatedAt, &user.AuthMethod, &user.IsDisabled)
-	if err != nil {
-		return nil, err
-	}
-	return user, nil
+	return store.GetUserByUsername(username)
 }
 
 // GetUserByEmail retrieves a user by their email
 func GetUserByEmail(email string) (*models.User, error) {
-	user := &models.User{}
-	err := DB.QueryRow(
-		"SELECT id, username, email, password, avatar, created_at, COALESCE(auth_method, 'email'), COALESCE(is_disabled, 0) FROM users WHERE email = ?",
-		email,
-	).Scan(&user.ID, &user.Username, &user.Email, &user.Password, &user.Avatar, &user.CreatedAt, &user.AuthMethod, &user.IsDisabled)
-	if err != nil {
-		return nil, err
-	}
-	return user, nil
-}
-
-// SearchUsers searches for users by username
-func SearchUsers(query string, currentUserID int64) ([]models.UserResponse, error) {
-	rows, err := DB.Query(
-		`SELECT id, username, email, avatar, created_at FROM users 
-		WHERE username LIKE ? AND id != ? LIMIT 20`,
-		"%"+query+"%", currentUserID,
-	)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
+	return store.GetUserByEmail(email)
+}
 
-	var users []models.UserResponse
-	for rows.Next() {
-		var user models.UserResponse
-		if err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.Avatar, &user.CreatedAt); err != nil {
-			return nil, err
-		}
-		users = append(users, user)
-	}
-	return users, nil
+// UpdateUserAvatar sets a user's avatar URL
+func UpdateUserAvatar(userID int64, avatar string) error {
+	return store.UpdateUserAvatar(userID, avatar)
+}
+
+// SetUserPresence stores the presence state a user chose
+func SetUserPresence(userID int64, presence models.Presence) error {
+	return store.SetUserPresence(userID, presence)
+}
+
+// TouchLastSeen records that a user was connected at the given time. Invisible
+// users keep the last_seen_at from before they went invisible.
+func TouchLastSeen(userID int64, at time.Time) error {
+	return store.TouchLastSeen(userID, at)
+}
+
+// GetUserPresence returns the stored presence of each of the given users
+func GetUserPresence(userIDs []int64) (map[int64]models.UserPresence, error) {
+	return store.GetUserPresence(userIDs)
+}
+
+// SearchUsers ranks users whose usernames match a query for the current user,
+// continuing after a cursor when one is given, and reports whether more follow
+func SearchUsers(query string, currentUserID int64, limit int, after *models.UserSearchCursor) ([]models.UserSearchResult, bool, error) {
+	return store.SearchUsers(query, currentUserID, limit, after)
 }
 
 // Session queries
 
 // CreateSession creates a new session for a user
 func CreateSession(sessionID string, userID int64, expiresAt time.Time) error {
-	_, err := DB.Exec(
-		"INSERT INTO sessions (id, user_id, expires_at) VALUES (?, ?, ?)",
-		sessionID, userID, expiresAt,
-	)
-	return err
+	return store.CreateSession(sessionID, userID, expiresAt)
 }
 
 // GetSession retrieves a session by its ID
 func GetSession(sessionID string) (*models.Session, error) {
-	session := &models.Session{}
-	err := DB.QueryRow(
-		"SELECT id, user_id, created_at, expires_at FROM sessions WHERE id = ? AND expires_at > datetime('now')",
-		sessionID,
-	).Scan(&session.ID, &session.UserID, &session.CreatedAt, &session.ExpiresAt)
-	if err != nil {
-		return nil, err
-	}
-	return session, nil
+	return store.GetSession(sessionID)
 }
 
 // DeleteSession removes a session
 func DeleteSession(sessionID string) error {
-	_, err := DB.Exec("DELETE FROM sessions WHERE id = ?", sessionID)
-	return err
+	return store.DeleteSession(sessionID)
 }
 
 // DeleteUserSessions removes all sessions for a user
 func DeleteUserSessions(userID int64) error {
-	_, err := DB.Exec("DELETE FROM sessions WHERE user_id = ?", userID)
-	return err
+	return store.DeleteUserSessions(userID)
 }
 
 // Message queries
 
-// CreateMessage creates a new message
-func CreateMessage(senderID, receiverID int64, content, msgType string, expiresAt *time.Time) (*models.Message, error) {
-	result, err := DB.Exec(
-		"INSERT INTO messages (sender_id, receiver_id, content, type, expires_at) VALUES (?, ?, ?, ?, ?)",
-		senderID, receiverID, content, msgType, expiresAt,
-	)
-	if err != nil {
-		return nil, err
-	}
-
-	id, err := result.LastInsertId()
-	if err != nil {
-		return nil, err
-	}
-
-	return GetMessageByID(id)
+// CreateMessage creates a new message, optionally disappearing disappearAfter it
+// is read and quoting replyToID when non-zero
+func CreateMessage(senderID, receiverID int64, content, msgType string, disappearAfter time.Duration, clientID string, replyToID int64) (*models.Message, error) {
+	return store.CreateMessage(senderID, receiverID, content, msgType, disappearAfter, clientID, replyToID)
 }
 
 // GetMessageByID retrieves a message by its ID
 func GetMessageByID(id int64) (*models.Message, error) {
-	msg := &models.Message{}
-	err := DB.QueryRow(
-		"SELECT id, sender_id, receiver_id, content, type, expires_at, read_at, created_at FROM messages WHERE id = ?",
-		id,
-	).Scan(&msg.ID, &msg.SenderID, &msg.ReceiverID, &msg.Content, &msg.Type, &msg.ExpiresAt, &msg.ReadAt, &msg.CreatedAt)
-	if err != nil {
-		return nil, err
-	}
-	return msg, nil
-}
-
-// GetMessagesBetweenUsers retrieves messages between two users
-func GetMessagesBetweenUsers(userID1, userID2 int64, limit, offset int) ([]models.MessageWithSender, error) {
-	rows, err := DB.Query(
-		`SELECT m.id, m.sender_id, m.receiver_id, m.content, m.type, m.expires_at, m.read_at, m.created_at,
-		        u.username, u.avatar
-		FROM messages m
-		JOIN users u ON m.sender_id = u.id
-		WHERE ((m.sender_id = ? AND m.receiver_id = ?) OR (m.sender_id = ? AND m.receiver_id = ?))
-		  AND (m.expires_at IS NULL OR m.expires_at > datetime('now'))
-		ORDER BY m.created_at DESC
-		LIMIT ? OFFSET ?`,
-		userID1, userID2, userID2, userID1, limit, offset,
-	)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var messages []models.MessageWithSender
-	for rows.Next() {
-		var msg models.MessageWithSender
-		if err := rows.Scan(
-			&msg.ID, &msg.SenderID, &msg.ReceiverID, &msg.Content, &msg.Type,
-			&msg.ExpiresAt, &msg.ReadAt, &msg.CreatedAt,
-			&msg.SenderUsername, &msg.SenderAvatar,
-		); err != nil {
-			return nil, err
-		}
-		messages = append(messages, msg)
-	}
+	return store.GetMessageByID(id)
+}
 
-	// Reverse to get chronological order
-	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
-		messages[i], messages[j] = messages[j], messages[i]
-	}
+// GetMessageByClientID finds a message by its sender and client-generated ID
+func GetMessageByClientID(senderID int64, clientID string) (*models.Message, error) {
+	return store.GetMessageByClientID(senderID, clientID)
+}
 
-	return messages, nil
-}
-
-// GetConversations retrieves all conversations for a user
-func GetConversations(userID int64) ([]models.Conversation, error) {
-	rows, err := DB.Query(
-		`SELECT DISTINCT 
-			CASE WHEN m.sender_id = ? THEN m.receiver_id ELSE m.sender_id END as other_user_id
-		FROM messages m
-		WHERE m.sender_id = ? OR m.receiver_id = ?
-		ORDER BY m.created_at DESC`,
-		userID, userID, userID,
-	)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
+// GetMessagesBetweenUsers retrieves a page of the history between two users in
+// chronological order and reports whether more lies beyond it
+func GetMessagesBetweenUsers(userID1, userID2 int64, page models.Page) ([]models.MessageWithSender, bool, error) {
+	return store.GetMessagesBetweenUsers(userID1, userID2, page)
+}
 
-	var conversations []models.Conversation
-	seen := make(map[int64]bool)
+// GetConversations retrieves a page of a user's conversations, most recently
+// active first, and reports whether older ones follow
+func GetConversations(userID int64, page models.Page) ([]models.Conversation, bool, error) {
+	return store.GetConversations(userID, page)
+}
 
-	for rows.Next() {
-		var otherUserID int64
-		if err := rows.Scan(&otherUserID); err != nil {
-			return nil, err
-		}
+// UpdateMessageContent replaces a message's content and records the edit time
+func UpdateMessageContent(id int64, content string) (*models.Message, error) {
+	return store.UpdateMessageContent(id, content)
+}
 
-		if seen[otherUserID] {
-			continue
-		}
-		seen[otherUserID] = true
+// DeleteMessage permanently removes a message along with its media row
+func DeleteMessage(id int64) error {
+	return store.DeleteMessage(id)
+}
 
-		user, err := GetUserByID(otherUserID)
-		if err != nil {
-			continue
-		}
+// MarkMessagesAsRead marks unread messages from a sender to receiver as read,
+// up to upToID when non-zero, starting the countdown on disappearing messages
+func MarkMessagesAsRead(senderID, receiverID, upToID int64) (*models.Receipt, error) {
+	return store.MarkMessagesAsRead(senderID, receiverID, upToID)
+}
 
-		// Get last message
-		var lastMsg models.Message
-		err = DB.QueryRow(
-			`SELECT id, sender_id, receiver_id, content, type, expires_at, read_at, created_at
-			FROM messages
-			WHERE ((sender_id = ? AND receiver_id = ?) OR (sender_id = ? AND receiver_id = ?))
-			  AND (expires_at IS NULL OR expires_at > datetime('now'))
-			ORDER BY created_at DESC LIMIT 1`,
-			userID, otherUserID, otherUserID, userID,
-		).Scan(&lastMsg.ID, &lastMsg.SenderID, &lastMsg.ReceiverID, &lastMsg.Content,
-			&lastMsg.Type, &lastMsg.ExpiresAt, &lastMsg.ReadAt, &lastMsg.CreatedAt)
-
-		// Count unread messages
-		var unreadCount int
-		DB.QueryRow(
-			`SELECT COUNT(*) FROM messages
-			WHERE sender_id = ? AND receiver_id = ? AND read_at IS NULL`,
-			otherUserID, userID,
-		).Scan(&unreadCount)
-
-		conv := models.Conversation{
-			User:        user.ToResponse(),
-			UnreadCount: unreadCount,
-		}
-		if err == nil {
-			conv.LastMessage = &lastMsg
-		}
+// DeleteExpiredMessages removes up to limit expired messages and returns them
+func DeleteExpiredMessages(limit int) ([]models.Message, error) {
+	return store.DeleteExpiredMessages(limit)
+}
 
-		conversations = append(conversations, conv)
-	}
+// SearchMessages finds unexpired text messages containing every search term in
+// conversations the user belongs to, most relevant first, and reports whether
+// more results follow
+func SearchMessages(userID int64, search models.MessageSearch) ([]models.MessageSearchResult, bool, error) {
+	return store.SearchMessages(userID, search)
+}
+
+// Receipt queries
+
+// MarkMessagesDelivered records that the receiver's device got the given
+// messages and returns one receipt per sender for those not delivered before
+func MarkMessagesDelivered(receiverID int64, messageIDs []int64) ([]models.Receipt, error) {
+	return store.MarkMessagesDelivered(receiverID, messageIDs)
+}
+
+// SetReadReceipts turns a user's read receipts on or off
+func SetReadReceipts(userID int64, enabled bool) error {
+	return store.SetReadReceipts(userID, enabled)
+}
+
+// GetReadReceiptsDisabled reports which of the given users turned read receipts off
+func GetReadReceiptsDisabled(userIDs []int64) (map[int64]bool, error) {
+	return store.GetReadReceiptsDisabled(userIDs)
+}
+
+// Reply queries
+
+// FillReplyPreviews sets ReplyTo on each message that replies to another, as a
+// tombstone once the parent has expired or been deleted
+func FillReplyPreviews(messages ...*models.Message) error {
+	return store.FillReplyPreviews(messages...)
+}
+
+// GetThread retrieves the reply chain a message belongs to in chronological
+// order and reports whether more follows
+func GetThread(messageID int64, limit int) ([]models.MessageWithSender, bool, error) {
+	return store.GetThread(messageID, limit)
+}
+
+// Reaction queries
+
+// AddReaction records a user's emoji reaction to a message and reports whether
+// it is new
+func AddReaction(messageID, userID int64, emoji string) (bool, error) {
+	return store.AddReaction(messageID, userID, emoji)
+}
+
+// RemoveReaction deletes a user's emoji reaction to a message and reports
+// whether there was one
+func RemoveReaction(messageID, userID int64, emoji string) (bool, error) {
+	return store.RemoveReaction(messageID, userID, emoji)
+}
+
+// GetReactions aggregates the reactions to each of the given messages by emoji
+func GetReactions(messageIDs []int64) (map[int64][]models.ReactionSummary, error) {
+	return store.GetReactions(messageIDs)
+}
+
+// Event log queries
+
+// AppendUserEvents logs an event for each user and returns the sequence
+// number it got in each user's log. A non-zero messageID ties the entries to
+// that message so they are dropped when it is deleted.
+func AppendUserEvents(userIDs []int64, eventType, payload string, messageID int64) (map[int64]int64, error) {
+	return store.AppendUserEvents(userIDs, eventType, payload, messageID)
+}
+
+// GetUserEvents returns up to limit of a user's logged events after afterSeq, in order
+func GetUserEvents(userID, afterSeq int64, limit int) ([]models.UserEvent, error) {
+	return store.GetUserEvents(userID, afterSeq, limit)
+}
+
+// GetUserEventCursor returns the last sequence number handed out to a user and
+// the highest one pruned from their log
+func GetUserEventCursor(userID int64) (latest, floor int64, err error) {
+	return store.GetUserEventCursor(userID)
+}
+
+// PruneUserEvents deletes logged events created before cutoff, raising each
+// affected user's floor so older cursors are known to be incomplete
+func PruneUserEvents(cutoff time.Time) (int64, error) {
+	return store.PruneUserEvents(cutoff)
+}
+
+// Snap queries
+
+// CreateSnap sends a view-once snap whose content is a media ID
+func CreateSnap(senderID, receiverID int64, mediaID string, viewSeconds int, clientID string) (*models.Message, error) {
+	return store.CreateSnap(senderID, receiverID, mediaID, viewSeconds, clientID)
+}
+
+// OpenSnap records the recipient's first open of a snap, reporting false if it was already opened
+func OpenSnap(id, receiverID int64) (bool, error) {
+	return store.OpenSnap(id, receiverID)
+}
+
+// ClearSnap destroys an opened snap's content
+func ClearSnap(id int64) error {
+	return store.ClearSnap(id)
+}
+
+// GetOpenedSnaps lists opened snaps whose content has not been destroyed yet
+func GetOpenedSnaps() ([]models.Message, error) {
+	return store.GetOpenedSnaps()
+}
+
+// Group conversation queries
+
+// CreateGroup creates a group owned by creatorID with the given initial members
+func CreateGroup(creatorID int64, name, avatar string, memberIDs []int64) (*models.Group, error) {
+	return store.CreateGroup(creatorID, name, avatar, memberIDs)
+}
+
+// GetGroup retrieves a group and its members
+func GetGroup(groupID int64) (*models.Group, error) {
+	return store.GetGroup(groupID)
+}
+
+// GetGroupMember retrieves a user's membership in a group
+func GetGroupMember(groupID, userID int64) (*models.GroupMember, error) {
+	return store.GetGroupMember(groupID, userID)
+}
+
+// GetGroupMemberIDs returns the user IDs of every member of a group
+func GetGroupMemberIDs(groupID int64) ([]int64, error) {
+	return store.GetGroupMemberIDs(groupID)
+}
+
+// UpdateGroup changes a group's name and avatar
+func UpdateGroup(groupID int64, name, avatar string) error {
+	return store.UpdateGroup(groupID, name, avatar)
+}
+
+// AddGroupMember adds a user to a group
+func AddGroupMember(groupID, userID int64, role models.GroupRole) error {
+	return store.AddGroupMember(groupID, userID, role)
+}
+
+// RemoveGroupMember removes a user from a group
+func RemoveGroupMember(groupID, userID int64) error {
+	return store.RemoveGroupMember(groupID, userID)
+}
+
+// SetGroupMemberRole changes a member's role
+func SetGroupMemberRole(groupID, userID int64, role models.GroupRole) error {
+	return store.SetGroupMemberRole(groupID, userID, role)
+}
+
+// TransferGroupOwnership makes toID the group's owner and demotes fromID to admin
+func TransferGroupOwnership(groupID, fromID, toID int64) error {
+	return store.TransferGroupOwnership(groupID, fromID, toID)
+}
+
+// LeaveGroup removes a member, handing off ownership or deleting the group as needed
+func LeaveGroup(groupID, userID int64) (newOwnerID int64, deleted bool, removed []models.Message, err error) {
+	return store.LeaveGroup(groupID, userID)
+}
+
+// CreateGroupMessage posts a message to a group, quoting replyToID when non-zero
+func CreateGroupMessage(senderID, groupID int64, content, msgType, clientID string, replyToID int64) (*models.Message, error) {
+	return store.CreateGroupMessage(senderID, groupID, content, msgType, clientID, replyToID)
+}
+
+// GetGroupMessages retrieves a page of a group's history
+func GetGroupMessages(groupID int64, limit, offset int) ([]models.MessageWithSender, error) {
+	return store.GetGroupMessages(groupID, limit, offset)
+}
+
+// MarkGroupAsRead marks all of a group's messages as read for a member
+func MarkGroupAsRead(groupID, userID int64) error {
+	return store.MarkGroupAsRead(groupID, userID)
+}
+
+// Media queries
 
-	return conversations, nil
+// CreateMedia records an uploaded file
+func CreateMedia(media *models.Media) error {
+	return store.CreateMedia(media)
 }
 
-// MarkMessagesAsRead marks all messages from a sender to receiver as read
-func MarkMessagesAsRead(senderID, receiverID int64) error {
-	_, err := DB.Exec(
-		"UPDATE messages SET read_at = datetime('now') WHERE sender_id = ? AND receiver_id = ? AND read_at IS NULL",
-		senderID, receiverID,
-	)
-	return err
+// GetMedia retrieves an uploaded file's metadata by ID
+func GetMedia(id string) (*models.Media, error) {
+	return store.GetMedia(id)
 }
 
-// DeleteExpiredMessages removes messages that have expired
-func DeleteExpiredMessages() error {
-	_, err := DB.Exec("DELETE FROM messages WHERE expires_at IS NOT NULL AND expires_at < datetime('now')")
-	return err
+// AttachMedia links an unattached upload to the message that references it
+func AttachMedia(id string, messageID int64) error {
+	return store.AttachMedia(id, messageID)
+}
+
+// Retention queries
+
+// SetRetentionOverride creates or replaces a conversation's retention override
+func SetRetentionOverride(o *models.RetentionOverride) error {
+	return store.SetRetentionOverride(o)
+}
+
+// DeleteRetentionOverride returns a conversation to the global policy
+func DeleteRetentionOverride(o *models.RetentionOverride) error {
+	return store.DeleteRetentionOverride(o)
+}
+
+// GetRetentionOverrides lists every per-conversation override
+func GetRetentionOverrides() ([]models.RetentionOverride, error) {
+	return store.GetRetentionOverrides()
+}
+
+// PurgeMessages deletes up to limit messages older than cutoff under a
+// retention policy (nil for the global one) and returns what was removed
+func PurgeMessages(o *models.RetentionOverride, cutoff time.Time, limit int) ([]models.Message, error) {
+	return store.PurgeMessages(o, cutoff, limit)
+}
+
+// CountPurgeableMessages counts the messages PurgeMessages would remove
+func CountPurgeableMessages(o *models.RetentionOverride, cutoff time.Time) (int64, error) {
+	return store.CountPurgeableMessages(o, cutoff)
 }
 
 // Friend queries
 
-// CreateFriendRequest creates a friend request
-func CreateFriendRequest(userID, friendID int64) error {
-	_, err := DB.Exec(
-		"INSERT INTO friends (user_id, friend_id, status) VALUES (?, ?, 'pending')",
-		userID, friendID,
-	)
-	return err
+// CreateFriendRequest creates a friend request that lapses at expiresAt
+func CreateFriendRequest(userID, friendID int64, expiresAt time.Time) error {
+	return store.CreateFriendRequest(userID, friendID, expiresAt)
 }
 
 // GetFriendship retrieves a friendship record
 func GetFriendship(userID, friendID int64) (*models.Friend, error) {
-	friend := &models.Friend{}
-	err := DB.QueryRow(
-		`SELECT id, user_id, friend_id, status, created_at FROM friends 
-		WHERE (user_id = ? AND friend_id = ?) OR (user_id = ? AND friend_id = ?)`,
-		userID, friendID, friendID, userID,
-	).Scan(&friend.ID, &friend.UserID, &friend.FriendID, &friend.Status, &friend.CreatedAt)
-	if err != nil {
-		return nil, err
-	}
-	return friend, nil
+	return store.GetFriendship(userID, friendID)
+}
+
+// GetFriendRequest retrieves a pending, unexpired friend request by ID
+func GetFriendRequest(requestID int64) (*models.Friend, error) {
+	return store.GetFriendRequest(requestID)
 }
 
 // AcceptFriendRequest accepts a pending friend request
 func AcceptFriendRequest(requestID int64, userID int64) error {
-	result, err := DB.Exec(
-		"UPDATE friends SET status = 'accepted' WHERE id = ? AND friend_id = ? AND status = 'pending'",
-		requestID, userID,
-	)
-	if err != nil {
-		return err
-	}
+	return store.AcceptFriendRequest(requestID, userID)
+}
 
-	rows, _ := result.RowsAffected()
-	if rows == 0 {
-		return sql.ErrNoRows
-	}
-	return nil
+// DeclineFriendRequest deletes a pending request sent to userID
+func DeclineFriendRequest(requestID int64, userID int64) error {
+	return store.DeclineFriendRequest(requestID, userID)
+}
+
+// CancelFriendRequest withdraws a pending request sent by userID
+func CancelFriendRequest(requestID int64, userID int64) error {
+	return store.CancelFriendRequest(requestID, userID)
+}
+
+// DeleteExpiredFriendRequests removes pending requests past their expiry and
+// returns them
+func DeleteExpiredFriendRequests() ([]models.Friend, error) {
+	return store.DeleteExpiredFriendRequests()
 }
 
 // GetFriends retrieves all accepted friends for a user
 func GetFriends(userID int64) ([]models.UserResponse, error) {
-	rows, err := DB.Query(
-		`SELECT u.id, u.username, u.email, u.avatar, u.created_at
-		FROM users u
-		JOIN friends f ON (f.user_id = u.id OR f.friend_id = u.id)
-		WHERE ((f.user_id = ? OR f.friend_id = ?) AND f.status = 'accepted')
-		  AND u.id != ?`,
-		userID, userID, userID,
-	)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var friends []models.UserResponse
-	seen := make(map[int64]bool)
-	for rows.Next() {
-		var user models.UserResponse
-		if err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.Avatar, &user.CreatedAt); err != nil {
-			return nil, err
-		}
-		if !seen[user.ID] {
-			friends = append(friends, user)
-			seen[user.ID] = true
-		}
-	}
-	return friends, nil
+	return store.GetFriends(userID)
+}
+
+// GetFriendIDs returns the IDs of a user's accepted friends
+func GetFriendIDs(userID int64) ([]int64, error) {
+	return store.GetFriendIDs(userID)
 }
 
 // GetPendingFriendRequests retrieves pending friend requests for a user
 func GetPendingFriendRequests(userID int64) ([]models.FriendRequest, error) {
-	rows, err := DB.Query(
-		`SELECT f.id, u.id, u.username, u.email, u.avatar, u.created_at, f.status, f.created_at
-		FROM friends f
-		JOIN users u ON f.user_id = u.id
-		WHERE f.friend_id = ? AND f.status = 'pending'`,
-		userID,
-	)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var requests []models.FriendRequest
-	for rows.Next() {
-		var req models.FriendRequest
-		var userCreatedAt time.Time
-		if err := rows.Scan(
-			&req.ID, &req.From.ID, &req.From.Username, &req.From.Email,
-			&req.From.Avatar, &userCreatedAt, &req.Status, &req.CreatedAt,
-		); err != nil {
-			return nil, err
-		}
-		req.From.CreatedAt = userCreatedAt
-		requests = append(requests, req)
-	}
-	return requests, nil
+	return store.GetPendingFriendRequests(userID)
+}
+
+// GetOutgoingFriendRequests retrieves the pending requests a user has sent
+func GetOutgoingFriendRequests(userID int64) ([]models.OutgoingFriendRequest, error) {
+	return store.GetOutgoingFriendRequests(userID)
 }
 
-// DeleteFriend removes a friendship
+// DeleteFriend removes a friendship or pending request, leaving blocks in place
 func DeleteFriend(userID, friendID int64) error {
-	_, err := DB.Exec(
-		"DELETE FROM friends WHERE (user_id = ? AND friend_id = ?) OR (user_id = ? AND friend_id = ?)",
-		userID, friendID, friendID, userID,
-	)
-	return err
+	return store.DeleteFriend(userID, friendID)
+}
+
+// Block queries
+
+// BlockUser blocks blockedID for blockerID, dropping any friendship between them
+func BlockUser(blockerID, blockedID int64) error {
+	return store.BlockUser(blockerID, blockedID)
+}
+
+// UnblockUser lifts a block that blockerID placed on blockedID
+func UnblockUser(blockerID, blockedID int64) error {
+	return store.UnblockUser(blockerID, blockedID)
+}
+
+// GetBlockedUsers lists the users a user has blocked
+func GetBlockedUsers(userID int64) ([]models.UserResponse, error) {
+	return store.GetBlockedUsers(userID)
+}
+
+// IsBlocked reports whether either user has blocked the other
+func IsBlocked(userID1, userID2 int64) (bool, error) {
+	return store.IsBlocked(userID1, userID2)
 }
 
 // Admin functions
 
 // GetAllUsers returns all users with their active session info
 func GetAllUsers() ([]models.UserResponse, error) {
-	rows, err := DB.Query(`
-		SELECT u.id, u.username, u.email, u.avatar, u.created_at, 
-		       COALESCE(u.auth_method, 'email'), COALESCE(u.is_disabled, 0),
-		       CASE WHEN s.id IS NOT NULL THEN 1 ELSE 0 END as online
-		FROM users u
-		LEFT JOIN sessions s ON u.id = s.user_id AND s.expires_at > datetime('now')
-		ORDER BY u.created_at DESC
-	`)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var users []models.UserResponse
-	for rows.Next() {
-		var user models.UserResponse
-		err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.Avatar, 
-			&user.CreatedAt, &user.AuthMethod, &user.IsDisabled, &user.Online)
-		if err != nil {
-			return nil, err
-		}
-		users = append(users, user)
-	}
-	return users, nil
+	return store.GetAllUsers()
 }
 
 // DisableUser disables or enables a user account
 func DisableUser(userID int64, disabled bool) error {
-	disabledInt := 0
-	if disabled {
-		disabledInt = 1
-	}
-	_, err := DB.Exec("UPDATE users SET is_disabled = ? WHERE id = ?", disabledInt, userID)
-	return err
+	return store.DisableUser(userID, disabled)
 }
 
 // ResetUserPassword resets a user's password
 func ResetUserPassword(userID int64, newPassword string) error {
-	_, err := DB.Exec("UPDATE users SET password = ? WHERE id = ?", newPassword, userID)
-	return err
+	return store.ResetUserPassword(userID, newPassword)
 }
 
 // DeleteAllUserSessions deletes all sessions for a user (force logout)
 func DeleteAllUserSessions(userID int64) error {
-	_, err := DB.Exec("DELETE FROM sessions WHERE user_id = ?", userID)
-	return err
+	return store.DeleteUserSessions(userID)
 }
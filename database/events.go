@@ -0,0 +1,99 @@
+package database
+
+import (
+	"time"
+
+	"scuffedsnap/models"
+)
+
+// Event log queries
+
+// AppendUserEvents logs an event for each user and returns the sequence
+// number it got in each user's log. A non-zero messageID ties the entries to
+// that message so they are dropped when it is deleted.
+func (s *sqlStore) AppendUserEvents(userIDs []int64, eventType, payload string, messageID int64) (map[int64]int64, error) {
+	var message interface{}
+	if messageID != 0 {
+		message = messageID
+	}
+
+	seqs := make(map[int64]int64, len(userIDs))
+	createdAt := now()
+	err := s.inTx(func(tx *sqlTx) error {
+		for _, userID := range userIDs {
+			var seq int64
+			if err := tx.queryRow(
+				"UPDATE users SET event_seq = event_seq + 1 WHERE id = ? RETURNING event_seq",
+				userID,
+			).Scan(&seq); err != nil {
+				return err
+			}
+			if _, err := tx.exec(
+				"INSERT INTO user_events (user_id, seq, type, payload, message_id, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+				userID, seq, eventType, payload, message, createdAt,
+			); err != nil {
+				return err
+			}
+			seqs[userID] = seq
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return seqs, nil
+}
+
+// GetUserEvents returns up to limit of a user's logged events after afterSeq, in order
+func (s *sqlStore) GetUserEvents(userID, afterSeq int64, limit int) ([]models.UserEvent, error) {
+	rows, err := s.query(
+		"SELECT seq, type, payload, created_at FROM user_events WHERE user_id = ? AND seq > ? ORDER BY seq LIMIT ?",
+		userID, afterSeq, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.UserEvent
+	for rows.Next() {
+		var event models.UserEvent
+		if err := rows.Scan(&event.Seq, &event.Type, &event.Payload, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// GetUserEventCursor returns the last sequence number handed out to a user and
+// the highest one pruned from their log
+func (s *sqlStore) GetUserEventCursor(userID int64) (latest, floor int64, err error) {
+	err = s.queryRow("SELECT event_seq, event_floor FROM users WHERE id = ?", userID).Scan(&latest, &floor)
+	return latest, floor, err
+}
+
+// PruneUserEvents deletes logged events created before cutoff, raising each
+// affected user's floor so older cursors are known to be incomplete
+func (s *sqlStore) PruneUserEvents(cutoff time.Time) (int64, error) {
+	cutoff = cutoff.UTC()
+	var deleted int64
+	err := s.inTx(func(tx *sqlTx) error {
+		if _, err := tx.exec(
+			`UPDATE users SET event_floor = (
+				SELECT MAX(e.seq) FROM user_events e WHERE e.user_id = users.id AND e.created_at < ?
+			)
+			WHERE id IN (SELECT user_id FROM user_events WHERE created_at < ?)`,
+			cutoff, cutoff,
+		); err != nil {
+			return err
+		}
+		result, err := tx.exec("DELETE FROM user_events WHERE created_at < ?", cutoff)
+		if err != nil {
+			return err
+		}
+		deleted, err = result.RowsAffected()
+		return err
+	})
+	return deleted, err
+}
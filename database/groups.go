@@ -0,0 +1,361 @@
+package database
+
+import (
+	"database/sql"
+
+	"scuffedsnap/models"
+)
+
+// Group conversation queries
+
+// CreateGroup creates a group owned by creatorID with the given initial members
+func (s *sqlStore) CreateGroup(creatorID int64, name, avatar string, memberIDs []int64) (*models.Group, error) {
+	var groupID int64
+	err := s.inTx(func(tx *sqlTx) error {
+		var err error
+		groupID, err = tx.insert(
+			"INSERT INTO conversations (name, avatar, created_by) VALUES (?, ?, ?)",
+			name, avatar, creatorID,
+		)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.exec(
+			"INSERT INTO conversation_members (conversation_id, user_id, role) VALUES (?, ?, ?)",
+			groupID, creatorID, models.GroupRoleOwner,
+		); err != nil {
+			return err
+		}
+
+		seen := map[int64]bool{creatorID: true}
+		for _, memberID := range memberIDs {
+			if seen[memberID] {
+				continue
+			}
+			seen[memberID] = true
+			if _, err := tx.exec(
+				"INSERT INTO conversation_members (conversation_id, user_id, role) VALUES (?, ?, ?)",
+				groupID, memberID, models.GroupRoleMember,
+			); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetGroup(groupID)
+}
+
+// GetGroup retrieves a group and its members
+func (s *sqlStore) GetGroup(groupID int64) (*models.Group, error) {
+	group := &models.Group{}
+	err := s.queryRow(
+		"SELECT id, name, COALESCE(avatar, ''), COALESCE(created_by, 0), created_at FROM conversations WHERE id = ?",
+		groupID,
+	).Scan(&group.ID, &group.Name, &group.Avatar, &group.CreatedBy, &group.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.query(
+		`SELECT u.id, u.username, COALESCE(u.avatar, ''), u.created_at, cm.role, cm.last_read_message_id, cm.joined_at
+		FROM conversation_members cm
+		JOIN users u ON u.id = cm.user_id
+		WHERE cm.conversation_id = ?
+		ORDER BY cm.joined_at, u.id`,
+		groupID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var member models.GroupMember
+		if err := rows.Scan(
+			&member.User.ID, &member.User.Username, &member.User.Avatar, &member.User.CreatedAt,
+			&member.Role, &member.LastReadMessageID, &member.JoinedAt,
+		); err != nil {
+			return nil, err
+		}
+		member.User.Avatar = models.AvatarURL(member.User.ID, member.User.Avatar)
+		group.Members = append(group.Members, member)
+	}
+	return group, rows.Err()
+}
+
+// GetGroupMember retrieves a user's membership in a group
+func (s *sqlStore) GetGroupMember(groupID, userID int64) (*models.GroupMember, error) {
+	member := &models.GroupMember{}
+	err := s.queryRow(
+		`SELECT u.id, u.username, COALESCE(u.avatar, ''), u.created_at, cm.role, cm.last_read_message_id, cm.joined_at
+		FROM conversation_members cm
+		JOIN users u ON u.id = cm.user_id
+		WHERE cm.conversation_id = ? AND cm.user_id = ?`,
+		groupID, userID,
+	).Scan(
+		&member.User.ID, &member.User.Username, &member.User.Avatar, &member.User.CreatedAt,
+		&member.Role, &member.LastReadMessageID, &member.JoinedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	member.User.Avatar = models.AvatarURL(member.User.ID, member.User.Avatar)
+	return member, nil
+}
+
+// GetGroupMemberIDs returns the user IDs of every member of a group
+func (s *sqlStore) GetGroupMemberIDs(groupID int64) ([]int64, error) {
+	rows, err := s.query("SELECT user_id FROM conversation_members WHERE conversation_id = ?", groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// UpdateGroup changes a group's name and avatar
+func (s *sqlStore) UpdateGroup(groupID int64, name, avatar string) error {
+	result, err := s.exec("UPDATE conversations SET name = ?, avatar = ? WHERE id = ?", name, avatar, groupID)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// AddGroupMember adds a user to a group. Earlier history counts as already read.
+func (s *sqlStore) AddGroupMember(groupID, userID int64, role models.GroupRole) error {
+	_, err := s.exec(
+		`INSERT INTO conversation_members (conversation_id, user_id, role, last_read_message_id)
+		SELECT ?, ?, ?, COALESCE(MAX(id), 0) FROM messages WHERE conversation_id = ?`,
+		groupID, userID, role, groupID,
+	)
+	return err
+}
+
+// RemoveGroupMember removes a user from a group
+func (s *sqlStore) RemoveGroupMember(groupID, userID int64) error {
+	result, err := s.exec(
+		"DELETE FROM conversation_members WHERE conversation_id = ? AND user_id = ?",
+		groupID, userID,
+	)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// SetGroupMemberRole changes a member's role
+func (s *sqlStore) SetGroupMemberRole(groupID, userID int64, role models.GroupRole) error {
+	result, err := s.exec(
+		"UPDATE conversation_members SET role = ? WHERE conversation_id = ? AND user_id = ?",
+		role, groupID, userID,
+	)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// TransferGroupOwnership makes toID the group's owner and demotes fromID to
+// admin, in one transaction so the group never has two owners or none
+func (s *sqlStore) TransferGroupOwnership(groupID, fromID, toID int64) error {
+	return s.inTx(func(tx *sqlTx) error {
+		result, err := tx.exec(
+			"UPDATE conversation_members SET role = ? WHERE conversation_id = ? AND user_id = ?",
+			models.GroupRoleOwner, groupID, toID,
+		)
+		if err != nil {
+			return err
+		}
+		if rows, _ := result.RowsAffected(); rows == 0 {
+			return sql.ErrNoRows
+		}
+		_, err = tx.exec(
+			"UPDATE conversation_members SET role = ? WHERE conversation_id = ? AND user_id = ?",
+			models.GroupRoleAdmin, groupID, fromID,
+		)
+		return err
+	})
+}
+
+// LeaveGroup removes a member. If the owner leaves, ownership passes to the
+// longest-standing admin, or failing that the longest-standing member. The
+// group is deleted when its last member leaves. Returns the new owner's ID
+// (0 if unchanged), whether the group was deleted, and the deleted group's
+// image messages, whose stored files the caller should discard.
+func (s *sqlStore) LeaveGroup(groupID, userID int64) (int64, bool, []models.Message, error) {
+	var newOwnerID int64
+	var deleted bool
+	var removed []models.Message
+
+	err := s.inTx(func(tx *sqlTx) error {
+		var role models.GroupRole
+		if err := tx.queryRow(
+			"SELECT role FROM conversation_members WHERE conversation_id = ? AND user_id = ?",
+			groupID, userID,
+		).Scan(&role); err != nil {
+			return err
+		}
+
+		if _, err := tx.exec(
+			"DELETE FROM conversation_members WHERE conversation_id = ? AND user_id = ?",
+			groupID, userID,
+		); err != nil {
+			return err
+		}
+
+		var remaining int
+		if err := tx.queryRow(
+			"SELECT COUNT(*) FROM conversation_members WHERE conversation_id = ?",
+			groupID,
+		).Scan(&remaining); err != nil {
+			return err
+		}
+
+		if remaining == 0 {
+			deleted = true
+			var err error
+			removed, err = deleteGroupTx(tx, groupID)
+			return err
+		}
+
+		if role != models.GroupRoleOwner {
+			return nil
+		}
+
+		if err := tx.queryRow(
+			`SELECT user_id FROM conversation_members
+			WHERE conversation_id = ?
+			ORDER BY CASE role WHEN 'admin' THEN 0 ELSE 1 END, joined_at, user_id
+			LIMIT 1`,
+			groupID,
+		).Scan(&newOwnerID); err != nil {
+			return err
+		}
+		_, err := tx.exec(
+			"UPDATE conversation_members SET role = ? WHERE conversation_id = ? AND user_id = ?",
+			models.GroupRoleOwner, groupID, newOwnerID,
+		)
+		return err
+	})
+	if err != nil {
+		return 0, false, nil, err
+	}
+	return newOwnerID, deleted, removed, nil
+}
+
+// deleteGroupTx deletes a group and the media rows of its messages, which
+// would otherwise be left unattached, returning the messages that had media
+func deleteGroupTx(tx *sqlTx, groupID int64) ([]models.Message, error) {
+	rows, err := tx.query(
+		"SELECT "+messageColumns+" FROM messages m WHERE m.conversation_id = ? AND m.type IN ('image', 'snap')",
+		groupID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	var removed []models.Message
+	for rows.Next() {
+		var msg models.Message
+		if err := scanMessage(rows, &msg); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		removed = append(removed, msg)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.exec(
+		"DELETE FROM media WHERE message_id IN (SELECT id FROM messages WHERE conversation_id = ?)",
+		groupID,
+	); err != nil {
+		return nil, err
+	}
+	if _, err := tx.exec("DELETE FROM conversations WHERE id = ?", groupID); err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
+
+// CreateGroupMessage posts a message to a group, quoting replyToID when
+// non-zero. The sender's own message counts as read for them.
+func (s *sqlStore) CreateGroupMessage(senderID, groupID int64, content, msgType, clientID string, replyToID int64) (*models.Message, error) {
+	var id int64
+	err := s.inTx(func(tx *sqlTx) error {
+		var err error
+		id, err = tx.insert(
+			"INSERT INTO messages (sender_id, conversation_id, content, type, client_id, reply_to_id) VALUES (?, ?, ?, ?, ?, ?)",
+			senderID, groupID, content, msgType, nullIfEmpty(clientID), nullIfZero(replyToID),
+		)
+		if err != nil {
+			return err
+		}
+		_, err = tx.exec(
+			"UPDATE conversation_members SET last_read_message_id = ? WHERE conversation_id = ? AND user_id = ?",
+			id, groupID, senderID,
+		)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetMessageByID(id)
+}
+
+// GetGroupMessages retrieves a page of a group's history in chronological order
+func (s *sqlStore) GetGroupMessages(groupID int64, limit, offset int) ([]models.MessageWithSender, error) {
+	rows, err := s.query(
+		`SELECT `+messageColumns+`, u.username, COALESCE(u.avatar, '')
+		FROM messages m
+		JOIN users u ON m.sender_id = u.id
+		WHERE m.conversation_id = ?
+		  AND (m.expires_at IS NULL OR m.expires_at > ?)
+		ORDER BY m.created_at DESC, m.id DESC
+		LIMIT ? OFFSET ?`,
+		groupID, now(), limit, offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := scanMessagesWithSender(rows)
+	if err != nil {
+		return nil, err
+	}
+	return messages, s.annotateMessages(messages)
+}
+
+// MarkGroupAsRead moves a member's read marker to the group's latest message
+func (s *sqlStore) MarkGroupAsRead(groupID, userID int64) error {
+	_, err := s.exec(
+		`UPDATE conversation_members
+		SET last_read_message_id = (SELECT COALESCE(MAX(id), 0) FROM messages WHERE conversation_id = ?)
+		WHERE conversation_id = ? AND user_id = ?`,
+		groupID, groupID, userID,
+	)
+	return err
+}
@@ -0,0 +1,48 @@
+package database
+
+import (
+	"database/sql"
+
+	"scuffedsnap/models"
+)
+
+// Media queries
+
+// CreateMedia records an uploaded file
+func (s *sqlStore) CreateMedia(media *models.Media) error {
+	media.CreatedAt = now()
+	_, err := s.exec(
+		"INSERT INTO media (id, owner_id, mime_type, size, created_at) VALUES (?, ?, ?, ?, ?)",
+		media.ID, media.OwnerID, media.MimeType, media.Size, media.CreatedAt,
+	)
+	return err
+}
+
+// GetMedia retrieves an uploaded file's metadata by ID
+func (s *sqlStore) GetMedia(id string) (*models.Media, error) {
+	media := &models.Media{}
+	err := s.queryRow(
+		"SELECT id, owner_id, mime_type, size, COALESCE(message_id, 0), created_at FROM media WHERE id = ?",
+		id,
+	).Scan(&media.ID, &media.OwnerID, &media.MimeType, &media.Size, &media.MessageID, &media.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return media, nil
+}
+
+// AttachMedia links an unattached upload to the message that references it.
+// Returns sql.ErrNoRows if the media is missing or already attached.
+func (s *sqlStore) AttachMedia(id string, messageID int64) error {
+	result, err := s.exec(
+		"UPDATE media SET message_id = ? WHERE id = ? AND message_id IS NULL",
+		messageID, id,
+	)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"scuffedsnap/models"
+)
+
+func TestDeleteMessageRemovesMedia(t *testing.T) {
+	s := newInboxStore(t, 1, 0, 0)
+	if err := s.CreateMedia(&models.Media{ID: "upload", OwnerID: 1, MimeType: "image/png", Size: 1}); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := s.CreateMessage(1, 2, "upload", "image", 0, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AttachMedia("upload", msg.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.DeleteMessage(msg.ID); err != nil {
+		t.Fatal(err)
+	}
+	// The upload must not become attachable to another message
+	if _, err := s.GetMedia("upload"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("media after deleting its message: err = %v", err)
+	}
+}
+
+func TestLeavingLastMemberRemovesGroupMedia(t *testing.T) {
+	s := newInboxStore(t, 1, 0, 0)
+	group, err := s.CreateGroup(1, "trip", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateMedia(&models.Media{ID: "photo", OwnerID: 1, MimeType: "image/png", Size: 1}); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := s.CreateGroupMessage(1, group.ID, "photo", "image", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AttachMedia("photo", msg.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	_, deleted, removed, err := s.LeaveGroup(group.ID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !deleted || len(removed) != 1 || removed[0].Content != "photo" {
+		t.Fatalf("deleted = %v, removed = %+v; want the group deleted with its image", deleted, removed)
+	}
+	if _, err := s.GetMedia("photo"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("media after deleting its group: err = %v", err)
+	}
+}
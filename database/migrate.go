@@ -0,0 +1,311 @@
+package database
+
+import (
+	"crypto/rand"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migrations live in migrations/<dialect>/NNNN_name.up.sql and NNNN_name.down.sql
+//
+//go:embed migrations/*/*.sql
+var migrationFiles embed.FS
+
+const (
+	// migrationLockTimeout is how long to wait for another instance to finish migrating
+	migrationLockTimeout = 2 * time.Minute
+	// migrationLockStale is how old a lock must be before it is assumed abandoned
+	migrationLockStale = 15 * time.Minute
+)
+
+// ErrMigrationLocked is returned when another instance holds the migration lock
+var ErrMigrationLocked = errors.New("database: migration lock held by another instance")
+
+// Migration is a single numbered schema change
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus reports whether a migration has been applied
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	Applied   bool       `json:"applied"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+// loadMigrations reads the embedded migrations for a dialect, sorted by version
+func loadMigrations(dir string) ([]Migration, error) {
+	files, err := fs.Glob(migrationFiles, path.Join("migrations", dir, "*.sql"))
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, file := range files {
+		base := path.Base(file)
+
+		var direction string
+		switch {
+		case strings.HasSuffix(base, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(base, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("migration %s: expected .up.sql or .down.sql", base)
+		}
+
+		stem := strings.TrimSuffix(base, "."+direction+".sql")
+		num, name, ok := strings.Cut(stem, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s: expected NNNN_name", base)
+		}
+		version, err := strconv.Atoi(num)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: invalid version: %w", base, err)
+		}
+
+		body, err := migrationFiles.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, fmt.Errorf("migration %d has conflicting names %q and %q", version, m.Name, name)
+		}
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %04d_%s has no up step", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// ensureMigrationTables creates the bookkeeping tables if they are missing
+func (s *sqlStore) ensureMigrationTables() error {
+	_, err := s.db.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS schema_migrations_lock (
+		id INTEGER PRIMARY KEY,
+		locked_by TEXT NOT NULL,
+		locked_at TIMESTAMP NOT NULL
+	);
+	`)
+	return err
+}
+
+// lockMigrations takes the single-row migration lock, waiting for other
+// instances to release it. Locks older than migrationLockStale are broken.
+func (s *sqlStore) lockMigrations() (func(), error) {
+	owner := migrationLockOwner()
+	deadline := time.Now().Add(migrationLockTimeout)
+
+	for {
+		s.exec("DELETE FROM schema_migrations_lock WHERE id = 1 AND locked_at < ?", now().Add(-migrationLockStale))
+
+		_, err := s.exec(
+			"INSERT INTO schema_migrations_lock (id, locked_by, locked_at) VALUES (1, ?, ?)",
+			owner, now(),
+		)
+		if err == nil {
+			unlock := func() {
+				if _, err := s.exec("DELETE FROM schema_migrations_lock WHERE id = 1 AND locked_by = ?", owner); err != nil {
+					log.Printf("Failed to release migration lock: %v", err)
+				}
+			}
+			return unlock, nil
+		}
+
+		if time.Now().After(deadline) {
+			return nil, ErrMigrationLocked
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// migrationLockOwner identifies this process in the lock table
+func migrationLockOwner() string {
+	host, _ := os.Hostname()
+	b := make([]byte, 4)
+	rand.Read(b)
+	return fmt.Sprintf("%s:%d:%s", host, os.Getpid(), hex.EncodeToString(b))
+}
+
+// appliedMigrations returns applied versions mapped to when they ran
+func (s *sqlStore) appliedMigrations() (map[int]time.Time, error) {
+	rows, err := s.query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// runMigration executes one step and records it in a single transaction
+func (s *sqlStore) runMigration(m Migration, up bool) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	body, record := m.Up, "INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)"
+	args := []interface{}{m.Version, m.Name, now()}
+	if !up {
+		body, record = m.Down, "DELETE FROM schema_migrations WHERE version = ?"
+		args = args[:1]
+	}
+
+	if _, err := tx.Exec(body); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(s.dialect.rebind(record), args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// MigrateUp applies every pending migration and returns how many ran
+func (s *sqlStore) MigrateUp() (int, error) {
+	migrations, err := loadMigrations(s.dialect.name())
+	if err != nil {
+		return 0, err
+	}
+	if err := s.ensureMigrationTables(); err != nil {
+		return 0, err
+	}
+
+	unlock, err := s.lockMigrations()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		if err := s.runMigration(m, true); err != nil {
+			return count, fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+		}
+		log.Printf("Applied migration %04d_%s", m.Version, m.Name)
+		count++
+	}
+	return count, nil
+}
+
+// MigrateDown rolls back the most recent steps migrations and returns how many ran
+func (s *sqlStore) MigrateDown(steps int) (int, error) {
+	migrations, err := loadMigrations(s.dialect.name())
+	if err != nil {
+		return 0, err
+	}
+	if err := s.ensureMigrationTables(); err != nil {
+		return 0, err
+	}
+
+	unlock, err := s.lockMigrations()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for i := len(migrations) - 1; i >= 0 && count < steps; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if m.Down == "" {
+			return count, fmt.Errorf("migration %04d_%s has no down step", m.Version, m.Name)
+		}
+		if err := s.runMigration(m, false); err != nil {
+			return count, fmt.Errorf("rollback %04d_%s: %w", m.Version, m.Name, err)
+		}
+		log.Printf("Rolled back migration %04d_%s", m.Version, m.Name)
+		count++
+	}
+	return count, nil
+}
+
+// MigrationStatus lists every known migration and whether it has been applied
+func (s *sqlStore) MigrationStatus() ([]MigrationStatus, error) {
+	migrations, err := loadMigrations(s.dialect.name())
+	if err != nil {
+		return nil, err
+	}
+	if err := s.ensureMigrationTables(); err != nil {
+		return nil, err
+	}
+
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		status := MigrationStatus{Version: m.Version, Name: m.Name}
+		if at, ok := applied[m.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = &at
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
@@ -0,0 +1,49 @@
+-- Core tables: users, sessions, messages, friends
+CREATE TABLE IF NOT EXISTS users (
+	id BIGSERIAL PRIMARY KEY,
+	username TEXT UNIQUE NOT NULL,
+	email TEXT UNIQUE NOT NULL,
+	password TEXT NOT NULL,
+	avatar TEXT DEFAULT '',
+	auth_method TEXT DEFAULT 'email',
+	is_disabled BOOLEAN DEFAULT FALSE,
+	created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+	id TEXT PRIMARY KEY,
+	user_id BIGINT NOT NULL,
+	created_at TIMESTAMPTZ DEFAULT NOW(),
+	expires_at TIMESTAMPTZ NOT NULL,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS messages (
+	id BIGSERIAL PRIMARY KEY,
+	sender_id BIGINT NOT NULL,
+	receiver_id BIGINT NOT NULL,
+	content TEXT NOT NULL,
+	type TEXT DEFAULT 'text',
+	expires_at TIMESTAMPTZ,
+	read_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ DEFAULT NOW(),
+	FOREIGN KEY (sender_id) REFERENCES users(id) ON DELETE CASCADE,
+	FOREIGN KEY (receiver_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS friends (
+	id BIGSERIAL PRIMARY KEY,
+	user_id BIGINT NOT NULL,
+	friend_id BIGINT NOT NULL,
+	status TEXT DEFAULT 'pending',
+	created_at TIMESTAMPTZ DEFAULT NOW(),
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+	FOREIGN KEY (friend_id) REFERENCES users(id) ON DELETE CASCADE,
+	UNIQUE(user_id, friend_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_messages_sender ON messages(sender_id);
+CREATE INDEX IF NOT EXISTS idx_messages_receiver ON messages(receiver_id);
+CREATE INDEX IF NOT EXISTS idx_friends_user ON friends(user_id);
+CREATE INDEX IF NOT EXISTS idx_friends_friend ON friends(friend_id);
+CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id);
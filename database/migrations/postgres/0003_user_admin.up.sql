@@ -0,0 +1,2 @@
+-- Admin flag for users (replaces add_admin_column.sql)
+ALTER TABLE users ADD COLUMN IF NOT EXISTS is_admin BOOLEAN DEFAULT FALSE;
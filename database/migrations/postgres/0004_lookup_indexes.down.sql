@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_friends_composite;
+DROP INDEX IF EXISTS idx_messages_expires;
+DROP INDEX IF EXISTS idx_messages_created;
@@ -0,0 +1,4 @@
+-- Indexes from complete_database_setup.sql that the Go schema was missing
+CREATE INDEX IF NOT EXISTS idx_messages_created ON messages(created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_messages_expires ON messages(expires_at) WHERE expires_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_friends_composite ON friends(user_id, friend_id, status);
@@ -0,0 +1,8 @@
+DELETE FROM messages WHERE conversation_id IS NOT NULL;
+DROP INDEX IF EXISTS idx_messages_conversation;
+ALTER TABLE messages DROP CONSTRAINT IF EXISTS messages_target_check;
+ALTER TABLE messages DROP COLUMN IF EXISTS conversation_id;
+ALTER TABLE messages ALTER COLUMN receiver_id SET NOT NULL;
+
+DROP TABLE IF EXISTS conversation_members;
+DROP TABLE IF EXISTS conversations;
@@ -0,0 +1,26 @@
+-- Group conversations with member roles. Group messages reference a
+-- conversation instead of a receiver, so receiver_id becomes nullable.
+CREATE TABLE IF NOT EXISTS conversations (
+	id BIGSERIAL PRIMARY KEY,
+	name TEXT NOT NULL,
+	avatar TEXT DEFAULT '',
+	created_by BIGINT REFERENCES users(id) ON DELETE SET NULL,
+	created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS conversation_members (
+	conversation_id BIGINT NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+	user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	role TEXT NOT NULL DEFAULT 'member',
+	last_read_message_id BIGINT NOT NULL DEFAULT 0,
+	joined_at TIMESTAMPTZ DEFAULT NOW(),
+	PRIMARY KEY (conversation_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_conversation_members_user ON conversation_members(user_id);
+
+ALTER TABLE messages ALTER COLUMN receiver_id DROP NOT NULL;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS conversation_id BIGINT REFERENCES conversations(id) ON DELETE CASCADE;
+ALTER TABLE messages ADD CONSTRAINT messages_target_check CHECK ((receiver_id IS NULL) <> (conversation_id IS NULL));
+
+CREATE INDEX IF NOT EXISTS idx_messages_conversation ON messages(conversation_id, id);
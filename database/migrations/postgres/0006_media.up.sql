@@ -0,0 +1,11 @@
+-- Uploaded media referenced by image messages
+CREATE TABLE IF NOT EXISTS media (
+	id TEXT PRIMARY KEY,
+	owner_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	mime_type TEXT NOT NULL,
+	size BIGINT NOT NULL,
+	message_id BIGINT REFERENCES messages(id) ON DELETE SET NULL,
+	created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_media_message ON media(message_id);
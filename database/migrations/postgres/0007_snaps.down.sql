@@ -0,0 +1,2 @@
+ALTER TABLE messages DROP COLUMN IF EXISTS view_seconds;
+ALTER TABLE messages DROP COLUMN IF EXISTS opened_at;
@@ -0,0 +1,3 @@
+-- View-once snaps: when the recipient opened it and how long it stays viewable
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS opened_at TIMESTAMPTZ;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS view_seconds INTEGER;
@@ -0,0 +1,2 @@
+-- Disappearing messages expire this many seconds after they are read
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS disappear_seconds INTEGER;
@@ -0,0 +1,17 @@
+-- Per-conversation overrides of the global message retention policy.
+-- Groups are keyed by conversation_id, direct chats by the ordered user pair.
+CREATE TABLE IF NOT EXISTS retention_overrides (
+	id BIGSERIAL PRIMARY KEY,
+	conversation_id BIGINT REFERENCES conversations(id) ON DELETE CASCADE,
+	user_low BIGINT REFERENCES users(id) ON DELETE CASCADE,
+	user_high BIGINT REFERENCES users(id) ON DELETE CASCADE,
+	retention_days INTEGER NOT NULL CHECK (retention_days >= 0), -- 0 keeps messages forever
+	updated_at TIMESTAMPTZ DEFAULT NOW(),
+	CHECK (
+		(conversation_id IS NOT NULL AND user_low IS NULL AND user_high IS NULL)
+		OR (conversation_id IS NULL AND user_low IS NOT NULL AND user_high IS NOT NULL AND user_low < user_high)
+	)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_retention_group ON retention_overrides(conversation_id) WHERE conversation_id IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_retention_direct ON retention_overrides(user_low, user_high) WHERE user_low IS NOT NULL;
@@ -0,0 +1 @@
+ALTER TABLE friends DROP COLUMN IF EXISTS expires_at;
@@ -0,0 +1,2 @@
+-- Pending friend requests lapse at expires_at
+ALTER TABLE friends ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
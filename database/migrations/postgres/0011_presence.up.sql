@@ -0,0 +1,3 @@
+-- Persistent presence: the state a user chose and when they were last connected
+ALTER TABLE users ADD COLUMN IF NOT EXISTS presence TEXT NOT NULL DEFAULT 'online';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMPTZ;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_messages_client_id;
+ALTER TABLE messages DROP COLUMN IF EXISTS client_id;
@@ -0,0 +1,3 @@
+-- Client-generated message IDs, so retried sends are stored only once
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS client_id TEXT;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_client_id ON messages(sender_id, client_id) WHERE client_id IS NOT NULL;
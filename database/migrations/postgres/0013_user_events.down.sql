@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS user_events;
+ALTER TABLE users DROP COLUMN IF EXISTS event_floor;
+ALTER TABLE users DROP COLUMN IF EXISTS event_seq;
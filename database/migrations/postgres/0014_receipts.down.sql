@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN IF EXISTS read_receipts;
+ALTER TABLE messages DROP COLUMN IF EXISTS delivered_at;
@@ -0,0 +1,4 @@
+-- Full-text search over text messages
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS search tsvector
+	GENERATED ALWAYS AS (CASE WHEN type = 'text' THEN to_tsvector('simple', content) END) STORED;
+CREATE INDEX IF NOT EXISTS idx_messages_search ON messages USING GIN (search);
@@ -0,0 +1,16 @@
+-- Trigram index over usernames for ranked, typo-tolerant user search. Each
+-- lower-cased username is padded as '  name ' and split into three-character
+-- windows; new users are indexed when they are created.
+CREATE TABLE IF NOT EXISTS user_trigrams (
+	trigram TEXT NOT NULL,
+	user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	PRIMARY KEY (trigram, user_id)
+);
+
+INSERT INTO user_trigrams (trigram, user_id)
+WITH RECURSIVE windows (user_id, padded, pos) AS (
+	SELECT id, '  ' || LOWER(username) || ' ', 1 FROM users
+	UNION ALL
+	SELECT user_id, padded, pos + 1 FROM windows WHERE pos + 3 <= LENGTH(padded)
+)
+SELECT DISTINCT SUBSTR(padded, pos, 3), user_id FROM windows;
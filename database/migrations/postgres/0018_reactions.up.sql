@@ -0,0 +1,8 @@
+-- Emoji reactions: each user can react to a message once per emoji
+CREATE TABLE IF NOT EXISTS message_reactions (
+	message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+	user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	emoji TEXT NOT NULL,
+	created_at TIMESTAMPTZ DEFAULT NOW(),
+	PRIMARY KEY (message_id, user_id, emoji)
+);
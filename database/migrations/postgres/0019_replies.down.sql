@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_messages_reply_to;
+ALTER TABLE messages DROP COLUMN IF EXISTS reply_to_id;
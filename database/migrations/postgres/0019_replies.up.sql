@@ -0,0 +1,4 @@
+-- Quoted replies. reply_to_id has no foreign key: a reply outlives its parent
+-- and is shown with a tombstone once the parent expires or is deleted.
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS reply_to_id BIGINT;
+CREATE INDEX IF NOT EXISTS idx_messages_reply_to ON messages(reply_to_id) WHERE reply_to_id IS NOT NULL;
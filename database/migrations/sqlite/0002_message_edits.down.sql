@@ -0,0 +1,2 @@
+ALTER TABLE messages DROP COLUMN updated_at;
+ALTER TABLE messages DROP COLUMN edited;
@@ -0,0 +1,3 @@
+-- Track message edits (edited flag + last edit time)
+ALTER TABLE messages ADD COLUMN edited INTEGER DEFAULT 0;
+ALTER TABLE messages ADD COLUMN updated_at DATETIME;
@@ -0,0 +1,29 @@
+CREATE TABLE messages_old (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	sender_id INTEGER NOT NULL,
+	receiver_id INTEGER NOT NULL,
+	content TEXT NOT NULL,
+	type TEXT DEFAULT 'text',
+	expires_at DATETIME,
+	read_at DATETIME,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	edited INTEGER DEFAULT 0,
+	updated_at DATETIME,
+	FOREIGN KEY (sender_id) REFERENCES users(id) ON DELETE CASCADE,
+	FOREIGN KEY (receiver_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+INSERT INTO messages_old (id, sender_id, receiver_id, content, type, expires_at, read_at, created_at, edited, updated_at)
+SELECT id, sender_id, receiver_id, content, type, expires_at, read_at, created_at, edited, updated_at
+FROM messages WHERE conversation_id IS NULL;
+
+DROP TABLE messages;
+ALTER TABLE messages_old RENAME TO messages;
+
+CREATE INDEX IF NOT EXISTS idx_messages_sender ON messages(sender_id);
+CREATE INDEX IF NOT EXISTS idx_messages_receiver ON messages(receiver_id);
+CREATE INDEX IF NOT EXISTS idx_messages_created ON messages(created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_messages_expires ON messages(expires_at) WHERE expires_at IS NOT NULL;
+
+DROP TABLE IF EXISTS conversation_members;
+DROP TABLE IF EXISTS conversations;
@@ -0,0 +1,53 @@
+-- Group conversations with member roles. Group messages reference a
+-- conversation instead of a receiver, so receiver_id becomes nullable.
+CREATE TABLE IF NOT EXISTS conversations (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	avatar TEXT DEFAULT '',
+	created_by INTEGER,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE TABLE IF NOT EXISTS conversation_members (
+	conversation_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	role TEXT NOT NULL DEFAULT 'member',
+	last_read_message_id INTEGER NOT NULL DEFAULT 0,
+	joined_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (conversation_id, user_id),
+	FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_conversation_members_user ON conversation_members(user_id);
+
+CREATE TABLE messages_new (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	sender_id INTEGER NOT NULL,
+	receiver_id INTEGER,
+	conversation_id INTEGER,
+	content TEXT NOT NULL,
+	type TEXT DEFAULT 'text',
+	expires_at DATETIME,
+	read_at DATETIME,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	edited INTEGER DEFAULT 0,
+	updated_at DATETIME,
+	FOREIGN KEY (sender_id) REFERENCES users(id) ON DELETE CASCADE,
+	FOREIGN KEY (receiver_id) REFERENCES users(id) ON DELETE CASCADE,
+	FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
+	CHECK ((receiver_id IS NULL) <> (conversation_id IS NULL))
+);
+
+INSERT INTO messages_new (id, sender_id, receiver_id, content, type, expires_at, read_at, created_at, edited, updated_at)
+SELECT id, sender_id, receiver_id, content, type, expires_at, read_at, created_at, edited, updated_at FROM messages;
+
+DROP TABLE messages;
+ALTER TABLE messages_new RENAME TO messages;
+
+CREATE INDEX IF NOT EXISTS idx_messages_sender ON messages(sender_id);
+CREATE INDEX IF NOT EXISTS idx_messages_receiver ON messages(receiver_id);
+CREATE INDEX IF NOT EXISTS idx_messages_created ON messages(created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_messages_expires ON messages(expires_at) WHERE expires_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_messages_conversation ON messages(conversation_id, id);
@@ -0,0 +1,13 @@
+-- Uploaded media referenced by image messages
+CREATE TABLE IF NOT EXISTS media (
+	id TEXT PRIMARY KEY,
+	owner_id INTEGER NOT NULL,
+	mime_type TEXT NOT NULL,
+	size INTEGER NOT NULL,
+	message_id INTEGER,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (owner_id) REFERENCES users(id) ON DELETE CASCADE,
+	FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_media_message ON media(message_id);
@@ -0,0 +1,2 @@
+ALTER TABLE messages DROP COLUMN view_seconds;
+ALTER TABLE messages DROP COLUMN opened_at;
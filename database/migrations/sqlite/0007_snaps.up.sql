@@ -0,0 +1,3 @@
+-- View-once snaps: when the recipient opened it and how long it stays viewable
+ALTER TABLE messages ADD COLUMN opened_at DATETIME;
+ALTER TABLE messages ADD COLUMN view_seconds INTEGER;
@@ -0,0 +1,21 @@
+-- Per-conversation overrides of the global message retention policy.
+-- Groups are keyed by conversation_id, direct chats by the ordered user pair.
+CREATE TABLE IF NOT EXISTS retention_overrides (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	conversation_id INTEGER,
+	user_low INTEGER,
+	user_high INTEGER,
+	retention_days INTEGER NOT NULL, -- 0 keeps messages forever
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
+	FOREIGN KEY (user_low) REFERENCES users(id) ON DELETE CASCADE,
+	FOREIGN KEY (user_high) REFERENCES users(id) ON DELETE CASCADE,
+	CHECK (
+		(conversation_id IS NOT NULL AND user_low IS NULL AND user_high IS NULL)
+		OR (conversation_id IS NULL AND user_low IS NOT NULL AND user_high IS NOT NULL AND user_low < user_high)
+	),
+	CHECK (retention_days >= 0)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_retention_group ON retention_overrides(conversation_id) WHERE conversation_id IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_retention_direct ON retention_overrides(user_low, user_high) WHERE user_low IS NOT NULL;
@@ -0,0 +1 @@
+ALTER TABLE friends DROP COLUMN expires_at;
@@ -0,0 +1,3 @@
+-- Persistent presence: the state a user chose and when they were last connected
+ALTER TABLE users ADD COLUMN presence TEXT NOT NULL DEFAULT 'online';
+ALTER TABLE users ADD COLUMN last_seen_at DATETIME;
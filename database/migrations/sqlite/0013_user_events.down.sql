@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS user_events;
+ALTER TABLE users DROP COLUMN event_floor;
+ALTER TABLE users DROP COLUMN event_seq;
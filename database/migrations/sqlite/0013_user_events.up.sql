@@ -0,0 +1,20 @@
+-- Durable per-user log of WebSocket events, replayed to clients that reconnect.
+-- users.event_seq hands out each user's next sequence number; event_floor is
+-- the highest seq pruned from the log, so older cursors can't be replayed.
+ALTER TABLE users ADD COLUMN event_seq INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN event_floor INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS user_events (
+	user_id INTEGER NOT NULL,
+	seq INTEGER NOT NULL,
+	type TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	message_id INTEGER, -- Set for events carrying a message, dropped along with it
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (user_id, seq),
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+	FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_events_message ON user_events(message_id) WHERE message_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_user_events_created ON user_events(created_at);
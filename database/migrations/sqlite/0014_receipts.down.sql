@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN read_receipts;
+ALTER TABLE messages DROP COLUMN delivered_at;
@@ -0,0 +1,3 @@
+-- Per-message delivery receipts and a per-user switch for read receipts
+ALTER TABLE messages ADD COLUMN delivered_at DATETIME;
+ALTER TABLE users ADD COLUMN read_receipts BOOLEAN NOT NULL DEFAULT TRUE;
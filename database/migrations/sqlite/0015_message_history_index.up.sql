@@ -0,0 +1,3 @@
+-- Keyset pagination of direct message history walks (created_at, id) within
+-- each direction of a conversation pair
+CREATE INDEX IF NOT EXISTS idx_messages_pair_history ON messages(sender_id, receiver_id, created_at, id);
@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS messages_fts_update_new;
+DROP TRIGGER IF EXISTS messages_fts_update_old;
+DROP TRIGGER IF EXISTS messages_fts_delete;
+DROP TRIGGER IF EXISTS messages_fts_insert;
+DROP TABLE IF EXISTS messages_fts;
@@ -0,0 +1,23 @@
+-- Full-text search over text messages. FTS5 needs a driver build tag, so this
+-- uses FTS4, which the default build includes, as an external-content index
+-- kept in sync by triggers.
+CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts4(content="messages", content, tokenize=unicode61);
+
+INSERT INTO messages_fts (docid, content) SELECT id, content FROM messages WHERE type = 'text';
+
+CREATE TRIGGER IF NOT EXISTS messages_fts_insert AFTER INSERT ON messages WHEN new.type = 'text' BEGIN
+	INSERT INTO messages_fts (docid, content) VALUES (new.id, new.content);
+END;
+
+-- The index reads the old content from messages to remove it, so deletes run first
+CREATE TRIGGER IF NOT EXISTS messages_fts_delete BEFORE DELETE ON messages WHEN old.type = 'text' BEGIN
+	DELETE FROM messages_fts WHERE docid = old.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS messages_fts_update_old BEFORE UPDATE OF content ON messages WHEN old.type = 'text' BEGIN
+	DELETE FROM messages_fts WHERE docid = old.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS messages_fts_update_new AFTER UPDATE OF content ON messages WHEN new.type = 'text' BEGIN
+	INSERT INTO messages_fts (docid, content) VALUES (new.id, new.content);
+END;
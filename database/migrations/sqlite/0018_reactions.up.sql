@@ -0,0 +1,8 @@
+-- Emoji reactions: each user can react to a message once per emoji
+CREATE TABLE IF NOT EXISTS message_reactions (
+	message_id INTEGER NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	emoji TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (message_id, user_id, emoji)
+);
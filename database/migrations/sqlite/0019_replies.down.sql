@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_messages_reply_to;
+ALTER TABLE messages DROP COLUMN reply_to_id;
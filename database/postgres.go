@@ -0,0 +1,82 @@
+package database
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// PostgresStore is a Store backed by a PostgreSQL database
+type PostgresStore struct {
+	sqlStore
+}
+
+// NewPostgresStore connects to the PostgreSQL database at databaseURL
+func NewPostgresStore(databaseURL string) (*PostgresStore, error) {
+	db, err := sql.Open("postgres", databaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	// Set connection pool settings
+	db.SetMaxOpenConns(25)
+	db.SetMaxIdleConns(5)
+	db.SetConnMaxLifetime(5 * time.Minute)
+
+	return &PostgresStore{sqlStore{db: db, dialect: postgresDialect{}}}, nil
+}
+
+type postgresDialect struct{}
+
+// rebind turns ? placeholders into $1, $2, ... skipping quoted literals
+func (postgresDialect) rebind(query string) string {
+	var b strings.Builder
+	b.Grow(len(query) + 16)
+
+	n := 0
+	inQuote := false
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'':
+			inQuote = !inQuote
+			b.WriteByte(c)
+		case c == '?' && !inQuote:
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func (postgresDialect) name() string {
+	return "postgres"
+}
+
+// timestamp passes t through: TIMESTAMPTZ columns compare by instant
+func (postgresDialect) timestamp(t time.Time) interface{} {
+	return t
+}
+
+// messageSearch matches against the generated search tsvector, ranked by
+// ts_rank. Terms are ANDed, like the SQLite backend.
+func (postgresDialect) messageSearch(terms []string) textSearch {
+	return textSearch{
+		from:      "messages m CROSS JOIN plainto_tsquery('simple', ?) AS q",
+		match:     "m.search @@ q",
+		highlight: `ts_headline('simple', m.content, q, 'StartSel="' || chr(1) || '", StopSel="' || chr(2) || '", MaxWords=24, MinWords=8')`,
+		rank:      "ts_rank(m.search, q)",
+		arg:       strings.Join(terms, " "),
+	}
+}
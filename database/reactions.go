@@ -0,0 +1,133 @@
+package database
+
+import (
+	"errors"
+
+	"scuffedsnap/models"
+)
+
+// MaxReactionsPerUser caps how many different emoji one user can put on a
+// single message
+const MaxReactionsPerUser = 20
+
+// ErrTooManyReactions is returned when a user already has MaxReactionsPerUser
+// reactions on a message
+var ErrTooManyReactions = errors.New("database: too many reactions on message")
+
+// Reaction queries
+
+// AddReaction records a user's emoji reaction to a message and reports whether
+// it is new. Reactions to a direct message are refused with ErrBlocked once
+// either participant has blocked the other.
+func (s *sqlStore) AddReaction(messageID, userID int64, emoji string) (bool, error) {
+	added := false
+	err := s.inTx(func(tx *sqlTx) error {
+		var senderID, receiverID int64
+		if err := tx.queryRow(
+			"SELECT sender_id, COALESCE(receiver_id, 0) FROM messages WHERE id = ?", messageID,
+		).Scan(&senderID, &receiverID); err != nil {
+			return err
+		}
+		if receiverID != 0 {
+			if err := checkNotBlocked(tx, senderID, receiverID); err != nil {
+				return err
+			}
+		}
+
+		var existing, mine int
+		if err := tx.queryRow(
+			`SELECT COUNT(*), COALESCE(SUM(CASE WHEN emoji = ? THEN 1 ELSE 0 END), 0)
+			FROM message_reactions WHERE message_id = ? AND user_id = ?`,
+			emoji, messageID, userID,
+		).Scan(&existing, &mine); err != nil {
+			return err
+		}
+		if mine > 0 {
+			return nil
+		}
+		if existing >= MaxReactionsPerUser {
+			return ErrTooManyReactions
+		}
+
+		if _, err := tx.exec(
+			"INSERT INTO message_reactions (message_id, user_id, emoji, created_at) VALUES (?, ?, ?, ?)",
+			messageID, userID, emoji, now(),
+		); err != nil {
+			return err
+		}
+		added = true
+		return nil
+	})
+	return added, err
+}
+
+// RemoveReaction deletes a user's emoji reaction to a message and reports
+// whether there was one
+func (s *sqlStore) RemoveReaction(messageID, userID int64, emoji string) (bool, error) {
+	result, err := s.exec(
+		"DELETE FROM message_reactions WHERE message_id = ? AND user_id = ? AND emoji = ?",
+		messageID, userID, emoji,
+	)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// GetReactions aggregates the reactions to each of the given messages by
+// emoji, in the order each emoji was first used
+func (s *sqlStore) GetReactions(messageIDs []int64) (map[int64][]models.ReactionSummary, error) {
+	reactions := make(map[int64][]models.ReactionSummary)
+	if len(messageIDs) == 0 {
+		return reactions, nil
+	}
+
+	in, args := inList(messageIDs)
+	rows, err := s.query(
+		"SELECT message_id, emoji, user_id FROM message_reactions WHERE message_id IN "+in+" ORDER BY message_id, created_at, user_id",
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			messageID, userID int64
+			emoji             string
+		)
+		if err := rows.Scan(&messageID, &emoji, &userID); err != nil {
+			return nil, err
+		}
+		summaries := reactions[messageID]
+		i := 0
+		for i < len(summaries) && summaries[i].Emoji != emoji {
+			i++
+		}
+		if i == len(summaries) {
+			summaries = append(summaries, models.ReactionSummary{Emoji: emoji})
+		}
+		summaries[i].Count++
+		summaries[i].UserIDs = append(summaries[i].UserIDs, userID)
+		reactions[messageID] = summaries
+	}
+	return reactions, rows.Err()
+}
+
+// attachReactions fills in the reactions of each message in a fetched page
+func (s *sqlStore) attachReactions(messages []models.MessageWithSender) error {
+	ids := make([]int64, len(messages))
+	for i := range messages {
+		ids[i] = messages[i].ID
+	}
+	reactions, err := s.GetReactions(ids)
+	if err != nil {
+		return err
+	}
+	for i := range messages {
+		messages[i].Reactions = reactions[messages[i].ID]
+	}
+	return nil
+}
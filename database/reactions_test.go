@@ -0,0 +1,60 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestReactions(t *testing.T) {
+	s := newInboxStore(t, 2, 0, 1)
+	// newInboxStore's only message from user 1 to user 2
+	const messageID = 1
+
+	for _, r := range []struct {
+		userID int64
+		emoji  string
+		added  bool
+	}{
+		{2, "👍", true},
+		{2, "👍", false},
+		{1, "👍", true},
+		{2, "🎉", true},
+	} {
+		added, err := s.AddReaction(messageID, r.userID, r.emoji)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if added != r.added {
+			t.Fatalf("user %d adding %s: added = %v, want %v", r.userID, r.emoji, added, r.added)
+		}
+	}
+	if removed, err := s.RemoveReaction(messageID, 2, "🎉"); err != nil || !removed {
+		t.Fatalf("removing 🎉: removed = %v, err = %v", removed, err)
+	}
+
+	reactions, err := s.GetReactions([]int64{messageID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(reactions[messageID]); got != "[{👍 2 [2 1]}]" {
+		t.Fatalf("reactions = %s", got)
+	}
+
+	// User 2 already has 👍
+	for i := 0; i < MaxReactionsPerUser-1; i++ {
+		if _, err := s.AddReaction(messageID, 2, fmt.Sprintf("%c", '😀'+rune(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.AddReaction(messageID, 2, "🙃"); !errors.Is(err, ErrTooManyReactions) {
+		t.Fatalf("reacting past the limit: err = %v", err)
+	}
+
+	if err := s.BlockUser(2, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AddReaction(messageID, 1, "🔥"); !errors.Is(err, ErrBlocked) {
+		t.Fatalf("reacting after a block: err = %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package database
+
+import "scuffedsnap/models"
+
+// Receipt queries
+
+// MarkMessagesDelivered records that the receiver's device got the given
+// direct messages. IDs that aren't addressed to the receiver or were already
+// delivered are skipped; the rest come back as one receipt per sender.
+func (s *sqlStore) MarkMessagesDelivered(receiverID int64, messageIDs []int64) ([]models.Receipt, error) {
+	if len(messageIDs) == 0 {
+		return nil, nil
+	}
+
+	deliveredAt := now()
+	var receipts []models.Receipt
+	err := s.inTx(func(tx *sqlTx) error {
+		in, args := inList(messageIDs)
+		rows, err := tx.query(
+			"SELECT id, sender_id FROM messages WHERE receiver_id = ? AND delivered_at IS NULL AND id IN "+in+" ORDER BY id",
+			append([]interface{}{receiverID}, args...)...,
+		)
+		if err != nil {
+			return err
+		}
+		bySender := make(map[int64]int)
+		var ids []int64
+		for rows.Next() {
+			var id, senderID int64
+			if err := rows.Scan(&id, &senderID); err != nil {
+				rows.Close()
+				return err
+			}
+			i, ok := bySender[senderID]
+			if !ok {
+				i = len(receipts)
+				bySender[senderID] = i
+				receipts = append(receipts, models.Receipt{SenderID: senderID, ReceiverID: receiverID, At: deliveredAt})
+			}
+			receipts[i].MessageIDs = append(receipts[i].MessageIDs, id)
+			ids = append(ids, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+
+		in, args = inList(ids)
+		_, err = tx.exec(
+			"UPDATE messages SET delivered_at = ? WHERE delivered_at IS NULL AND id IN "+in,
+			append([]interface{}{deliveredAt}, args...)...,
+		)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return receipts, nil
+}
+
+// SetReadReceipts turns a user's read receipts on or off
+func (s *sqlStore) SetReadReceipts(userID int64, enabled bool) error {
+	_, err := s.exec("UPDATE users SET read_receipts = ? WHERE id = ?", enabled, userID)
+	return err
+}
+
+// GetReadReceiptsDisabled reports which of the given users turned read
+// receipts off; users who keep them on are absent from the map
+func (s *sqlStore) GetReadReceiptsDisabled(userIDs []int64) (map[int64]bool, error) {
+	disabled := make(map[int64]bool)
+	if len(userIDs) == 0 {
+		return disabled, nil
+	}
+
+	in, args := inList(userIDs)
+	rows, err := s.query("SELECT id FROM users WHERE read_receipts = FALSE AND id IN "+in, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		disabled[id] = true
+	}
+	return disabled, rows.Err()
+}
@@ -0,0 +1,37 @@
+package database
+
+import "testing"
+
+func TestMarkMessagesAsReadHandlesLongBacklogs(t *testing.T) {
+	// 40,000 unread messages from user 2, more than SQLite allows as
+	// parameters in one statement
+	s := newInboxStore(t, 1, 0, 0)
+	if _, err := s.exec(
+		`WITH RECURSIVE n (i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 40000)
+		INSERT INTO messages (sender_id, receiver_id, content) SELECT 2, 1, 'hello' FROM n`,
+	); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.exec("UPDATE messages SET disappear_seconds = 60 WHERE id = (SELECT MAX(id) FROM messages WHERE sender_id = 2)"); err != nil {
+		t.Fatal(err)
+	}
+
+	receipt, err := s.MarkMessagesAsRead(2, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(receipt.MessageIDs) != 40000 {
+		t.Fatalf("read %d messages, want 40000", len(receipt.MessageIDs))
+	}
+
+	var unread, timed int
+	if err := s.queryRow("SELECT COUNT(*) FROM messages WHERE sender_id = 2 AND read_at IS NULL").Scan(&unread); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.queryRow("SELECT COUNT(*) FROM messages WHERE expires_at IS NOT NULL").Scan(&timed); err != nil {
+		t.Fatal(err)
+	}
+	if unread != 0 || timed != 1 {
+		t.Fatalf("unread = %d, timers started = %d; want 0 and 1", unread, timed)
+	}
+}
@@ -0,0 +1,144 @@
+package database
+
+import (
+	"unicode/utf8"
+
+	"scuffedsnap/models"
+)
+
+// maxPreviewRunes bounds the quoted text in a reply preview
+const maxPreviewRunes = 100
+
+// maxThreadDepth bounds the walk from a message up to its thread's root
+const maxThreadDepth = 1000
+
+// Reply queries
+
+// FillReplyPreviews sets ReplyTo on each message that replies to another. A
+// parent that has expired or been deleted becomes a tombstone.
+func (s *sqlStore) FillReplyPreviews(messages ...*models.Message) error {
+	var parentIDs []int64
+	for _, msg := range messages {
+		if msg.ReplyToID != 0 {
+			parentIDs = append(parentIDs, msg.ReplyToID)
+		}
+	}
+	if len(parentIDs) == 0 {
+		return nil
+	}
+
+	in, args := inList(parentIDs)
+	rows, err := s.query(
+		`SELECT m.id, m.sender_id, u.username, m.type, m.content
+		FROM messages m
+		JOIN users u ON u.id = m.sender_id
+		WHERE m.id IN `+in+` AND (m.expires_at IS NULL OR m.expires_at > ?)`,
+		append(args, now())...,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	previews := make(map[int64]*models.MessagePreview)
+	for rows.Next() {
+		preview := &models.MessagePreview{}
+		if err := rows.Scan(&preview.ID, &preview.SenderID, &preview.SenderUsername, &preview.Type, &preview.Content); err != nil {
+			return err
+		}
+		// Images and snaps are quoted by type alone
+		if preview.Type != "text" {
+			preview.Content = ""
+		} else if utf8.RuneCountInString(preview.Content) > maxPreviewRunes {
+			preview.Content = string([]rune(preview.Content)[:maxPreviewRunes]) + "…"
+		}
+		previews[preview.ID] = preview
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, msg := range messages {
+		if msg.ReplyToID == 0 {
+			continue
+		}
+		if preview, ok := previews[msg.ReplyToID]; ok {
+			quoted := *preview
+			msg.ReplyTo = &quoted
+		} else {
+			msg.ReplyTo = &models.MessagePreview{ID: msg.ReplyToID, Deleted: true}
+		}
+	}
+	return nil
+}
+
+// GetThread retrieves the reply chain a message belongs to: its root and every
+// reply beneath it, in chronological order, up to limit messages. It reports
+// whether more follow. Replies to a root that has since been deleted still
+// share its thread.
+func (s *sqlStore) GetThread(messageID int64, limit int) ([]models.MessageWithSender, bool, error) {
+	rows, err := s.query(
+		`WITH RECURSIVE ancestors (id, reply_to_id, depth) AS (
+			SELECT id, reply_to_id, 0 FROM messages WHERE id = ?
+			UNION ALL
+			SELECT p.id, p.reply_to_id, a.depth + 1
+			FROM messages p
+			JOIN ancestors a ON p.id = a.reply_to_id
+			WHERE a.depth < ?
+		),
+		root AS (
+			SELECT COALESCE(reply_to_id, id) AS id FROM ancestors ORDER BY depth DESC LIMIT 1
+		),
+		thread (id) AS (
+			SELECT id FROM root
+			UNION
+			SELECT r.id FROM messages r JOIN thread t ON r.reply_to_id = t.id
+		)
+		SELECT `+messageColumns+`, u.username, COALESCE(u.avatar, '')
+		FROM thread t
+		JOIN messages m ON m.id = t.id
+		JOIN users u ON m.sender_id = u.id
+		WHERE m.expires_at IS NULL OR m.expires_at > ?
+		ORDER BY m.created_at, m.id
+		LIMIT ?`,
+		messageID, maxThreadDepth, now(), limit+1,
+	)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	var messages []models.MessageWithSender
+	for rows.Next() {
+		var msg models.MessageWithSender
+		if err := scanMessageWithSender(rows, &msg); err != nil {
+			return nil, false, err
+		}
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	// One extra row tells whether there is more
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[:limit]
+	}
+	if err := s.annotateMessages(messages); err != nil {
+		return nil, false, err
+	}
+	return messages, hasMore, nil
+}
+
+// annotateMessages fills in the reply previews and reactions of a fetched page
+func (s *sqlStore) annotateMessages(messages []models.MessageWithSender) error {
+	ptrs := make([]*models.Message, len(messages))
+	for i := range messages {
+		ptrs[i] = &messages[i].Message
+	}
+	if err := s.FillReplyPreviews(ptrs...); err != nil {
+		return err
+	}
+	return s.attachReactions(messages)
+}
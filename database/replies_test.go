@@ -0,0 +1,75 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"scuffedsnap/models"
+)
+
+func TestRepliesAndThreads(t *testing.T) {
+	s := newInboxStore(t, 1, 0, 0)
+	send := func(sender, receiver int64, content string, replyToID int64) int64 {
+		t.Helper()
+		msg, err := s.CreateMessage(sender, receiver, content, "text", 0, "", replyToID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg.ID
+	}
+	thread := func(messageID int64) string {
+		t.Helper()
+		messages, _, err := s.GetThread(messageID, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := make([]int64, len(messages))
+		for i, msg := range messages {
+			ids[i] = msg.ID
+		}
+		return fmt.Sprint(ids)
+	}
+
+	root := send(1, 2, strings.Repeat("long ", 30), 0)
+	first := send(2, 1, "first", root)
+	nested := send(1, 2, "nested", first)
+	second := send(2, 1, "second", root)
+	unrelated := send(1, 2, "unrelated", 0)
+
+	want := fmt.Sprint([]int64{root, first, nested, second})
+	if got := thread(nested); got != want {
+		t.Fatalf("thread of nested reply = %s, want %s", got, want)
+	}
+	if got := thread(unrelated); got != fmt.Sprint([]int64{unrelated}) {
+		t.Fatalf("thread of unrelated message = %s", got)
+	}
+
+	msg, err := s.GetMessageByID(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.FillReplyPreviews(msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.ReplyTo == nil || msg.ReplyTo.Deleted || msg.ReplyTo.SenderUsername != "user1" ||
+		!strings.HasSuffix(msg.ReplyTo.Content, "…") || len([]rune(msg.ReplyTo.Content)) != maxPreviewRunes+1 {
+		t.Fatalf("preview = %+v", msg.ReplyTo)
+	}
+
+	// Deleting the root leaves tombstones, and its replies still share a thread
+	if err := s.DeleteMessage(root); err != nil {
+		t.Fatal(err)
+	}
+	msg.ReplyTo = nil
+	if err := s.FillReplyPreviews(msg); err != nil {
+		t.Fatal(err)
+	}
+	if *msg.ReplyTo != (models.MessagePreview{ID: root, Deleted: true}) {
+		t.Fatalf("preview after delete = %+v", msg.ReplyTo)
+	}
+	want = fmt.Sprint([]int64{first, nested, second})
+	if got := thread(second); got != want {
+		t.Fatalf("thread after deleting root = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,106 @@
+package database
+
+import (
+	"time"
+
+	"scuffedsnap/models"
+)
+
+// Retention queries
+
+// overrideKey is the WHERE clause selecting an override's row
+func overrideKey(o *models.RetentionOverride) (string, []interface{}) {
+	if o.ConversationID != 0 {
+		return "conversation_id = ?", []interface{}{o.ConversationID}
+	}
+	return "user_low = ? AND user_high = ?", []interface{}{o.UserLow, o.UserHigh}
+}
+
+// SetRetentionOverride creates or replaces a conversation's retention override
+func (s *sqlStore) SetRetentionOverride(o *models.RetentionOverride) error {
+	o.UpdatedAt = now()
+	where, args := overrideKey(o)
+
+	var conversationID, userLow, userHigh interface{}
+	if o.ConversationID != 0 {
+		conversationID = o.ConversationID
+	} else {
+		userLow, userHigh = o.UserLow, o.UserHigh
+	}
+
+	return s.inTx(func(tx *sqlTx) error {
+		if _, err := tx.exec("DELETE FROM retention_overrides WHERE "+where, args...); err != nil {
+			return err
+		}
+		_, err := tx.exec(
+			"INSERT INTO retention_overrides (conversation_id, user_low, user_high, retention_days, updated_at) VALUES (?, ?, ?, ?, ?)",
+			conversationID, userLow, userHigh, o.RetentionDays, o.UpdatedAt,
+		)
+		return err
+	})
+}
+
+// DeleteRetentionOverride returns a conversation to the global policy
+func (s *sqlStore) DeleteRetentionOverride(o *models.RetentionOverride) error {
+	where, args := overrideKey(o)
+	_, err := s.exec("DELETE FROM retention_overrides WHERE "+where, args...)
+	return err
+}
+
+// GetRetentionOverrides lists every per-conversation override
+func (s *sqlStore) GetRetentionOverrides() ([]models.RetentionOverride, error) {
+	rows, err := s.query(
+		`SELECT COALESCE(conversation_id, 0), COALESCE(user_low, 0), COALESCE(user_high, 0), retention_days, updated_at
+		FROM retention_overrides ORDER BY id`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var overrides []models.RetentionOverride
+	for rows.Next() {
+		var o models.RetentionOverride
+		if err := rows.Scan(&o.ConversationID, &o.UserLow, &o.UserHigh, &o.RetentionDays, &o.UpdatedAt); err != nil {
+			return nil, err
+		}
+		overrides = append(overrides, o)
+	}
+	return overrides, rows.Err()
+}
+
+// retentionScope selects the messages older than cutoff that a policy covers.
+// A nil override means the global policy, which skips overridden conversations.
+func retentionScope(o *models.RetentionOverride, cutoff time.Time) (string, []interface{}) {
+	switch {
+	case o == nil:
+		return `m.created_at < ? AND NOT EXISTS (
+			SELECT 1 FROM retention_overrides ro
+			WHERE ro.conversation_id = m.conversation_id
+			   OR (ro.user_low = m.sender_id AND ro.user_high = m.receiver_id)
+			   OR (ro.user_low = m.receiver_id AND ro.user_high = m.sender_id))`,
+			[]interface{}{cutoff}
+	case o.ConversationID != 0:
+		return "m.conversation_id = ? AND m.created_at < ?", []interface{}{o.ConversationID, cutoff}
+	default:
+		return `m.conversation_id IS NULL
+			AND ((m.sender_id = ? AND m.receiver_id = ?) OR (m.sender_id = ? AND m.receiver_id = ?))
+			AND m.created_at < ?`,
+			[]interface{}{o.UserLow, o.UserHigh, o.UserHigh, o.UserLow, cutoff}
+	}
+}
+
+// PurgeMessages deletes up to limit messages older than cutoff under a
+// retention policy (nil for the global one) and returns what was removed
+func (s *sqlStore) PurgeMessages(o *models.RetentionOverride, cutoff time.Time, limit int) ([]models.Message, error) {
+	where, args := retentionScope(o, cutoff.UTC())
+	return s.deleteMessagesWhere(where, args, limit)
+}
+
+// CountPurgeableMessages counts the messages PurgeMessages would remove
+func (s *sqlStore) CountPurgeableMessages(o *models.RetentionOverride, cutoff time.Time) (int64, error) {
+	where, args := retentionScope(o, cutoff.UTC())
+	var count int64
+	err := s.queryRow("SELECT COUNT(*) FROM messages m WHERE "+where, args...).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,233 @@
+package database
+
+import (
+	"strings"
+	"unicode"
+
+	"scuffedsnap/models"
+)
+
+// Search queries
+
+// textSearch is a backend's full-text search over messages: a FROM clause
+// exposing messages as m, a match condition taking arg, and expressions for a
+// highlighted excerpt and a relevance rank, higher first. The excerpt wraps
+// matches in \x01 and \x02.
+type textSearch struct {
+	from, match, highlight, rank string
+	arg                          interface{}
+}
+
+// searchTerms splits a search query into words, dropping punctuation so user
+// input can never be read as query syntax
+func searchTerms(query string) []string {
+	return strings.FieldsFunc(query, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// SearchMessages finds unexpired text messages containing every search term in
+// conversations the user belongs to, most relevant first, and reports whether
+// more results follow
+func (s *sqlStore) SearchMessages(userID int64, search models.MessageSearch) ([]models.MessageSearchResult, bool, error) {
+	terms := searchTerms(search.Query)
+	if len(terms) == 0 {
+		return nil, false, nil
+	}
+	text := s.dialect.messageSearch(terms)
+
+	where := []string{
+		text.match,
+		"(m.expires_at IS NULL OR m.expires_at > ?)",
+		`((m.conversation_id IS NULL AND (m.sender_id = ? OR m.receiver_id = ?))
+		  OR m.conversation_id IN (SELECT conversation_id FROM conversation_members WHERE user_id = ?))`,
+	}
+	args := []interface{}{text.arg, now(), userID, userID, userID}
+	if search.WithUserID != 0 {
+		where = append(where, "((m.sender_id = ? AND m.receiver_id = ?) OR (m.sender_id = ? AND m.receiver_id = ?))")
+		args = append(args, userID, search.WithUserID, search.WithUserID, userID)
+	}
+	if search.ConversationID != 0 {
+		where = append(where, "m.conversation_id = ?")
+		args = append(args, search.ConversationID)
+	}
+	if search.SenderID != 0 {
+		where = append(where, "m.sender_id = ?")
+		args = append(args, search.SenderID)
+	}
+	if search.From != nil {
+		where = append(where, "m.created_at >= ?")
+		args = append(args, s.dialect.timestamp(*search.From))
+	}
+	if search.To != nil {
+		where = append(where, "m.created_at < ?")
+		args = append(args, s.dialect.timestamp(*search.To))
+	}
+	// One extra row tells whether there is more
+	args = append(args, search.Limit+1, search.Offset)
+
+	rows, err := s.query(
+		`SELECT `+messageColumns+`, u.username, COALESCE(u.avatar, ''), `+text.highlight+`, `+text.rank+` AS relevance
+		FROM `+text.from+`
+		JOIN users u ON u.id = m.sender_id
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY relevance DESC, m.id DESC
+		LIMIT ? OFFSET ?`,
+		args...,
+	)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	var results []models.MessageSearchResult
+	for rows.Next() {
+		var result models.MessageSearchResult
+		if err := scanMessageWithSender(rows, &result.MessageWithSender, &result.Highlight, &result.Rank); err != nil {
+			return nil, false, err
+		}
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	hasMore := len(results) > search.Limit
+	if hasMore {
+		results = results[:search.Limit]
+	}
+	return results, hasMore, nil
+}
+
+// Minimum similarity, in thousandths, for a username that doesn't contain the
+// query to count as a match
+const minUserSimilarity = 300
+
+// usernameTrigrams splits a lower-cased name into the three-character windows
+// of '  name ', the same padding the user_trigrams backfill uses
+func usernameTrigrams(name string) []string {
+	padded := []rune("  " + strings.ToLower(name) + " ")
+	seen := make(map[string]bool)
+	var trigrams []string
+	for i := 0; i+3 <= len(padded); i++ {
+		trigram := string(padded[i : i+3])
+		if !seen[trigram] {
+			seen[trigram] = true
+			trigrams = append(trigrams, trigram)
+		}
+	}
+	return trigrams
+}
+
+// indexUsername records a new user's username trigrams for SearchUsers
+func indexUsername(tx *sqlTx, userID int64, username string) error {
+	for _, trigram := range usernameTrigrams(username) {
+		if _, err := tx.exec("INSERT INTO user_trigrams (trigram, user_id) VALUES (?, ?)", trigram, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// likeEscaper escapes LIKE wildcards so the query matches literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// SearchUsers ranks users whose usernames match a query for the current user,
+// continuing after a cursor when one is given, and reports whether more follow.
+// Candidates come from the trigram index; exact matches rank first, then
+// prefix matches, then names containing the query, then names merely similar
+// to it. Within a tier, users sharing more friends with the searcher rank
+// higher. Disabled users and users blocked either way are skipped.
+func (s *sqlStore) SearchUsers(query string, currentUserID int64, limit int, after *models.UserSearchCursor) ([]models.UserSearchResult, bool, error) {
+	query = strings.ToLower(strings.TrimSpace(query))
+	if query == "" {
+		return nil, false, nil
+	}
+	trigrams := usernameTrigrams(query)
+	placeholders := make([]string, len(trigrams))
+	args := []interface{}{currentUserID, currentUserID, currentUserID}
+	for i, trigram := range trigrams {
+		placeholders[i] = "?"
+		args = append(args, trigram)
+	}
+	escaped := likeEscaper.Replace(query)
+	args = append(args,
+		query, escaped+"%", "%"+escaped+"%",
+		len(trigrams),
+		currentUserID, currentUserID, currentUserID,
+		minUserSimilarity,
+	)
+
+	keyset := ""
+	if after != nil {
+		keyset = `AND (tier > ? OR (tier = ? AND (mutual < ? OR (mutual = ? AND (score < ? OR (score = ? AND id > ?))))))`
+		args = append(args, after.Tier, after.Tier, after.MutualFriends, after.MutualFriends, after.Score, after.Score, after.ID)
+	}
+	// One extra row tells whether there is more
+	args = append(args, limit+1)
+
+	rows, err := s.query(
+		`WITH my_friends AS (
+			SELECT CASE WHEN user_id = ? THEN friend_id ELSE user_id END AS id
+			FROM friends
+			WHERE status = 'accepted' AND (user_id = ? OR friend_id = ?)
+		),
+		candidates AS (
+			SELECT user_id, COUNT(*) AS shared
+			FROM user_trigrams
+			WHERE trigram IN (`+strings.Join(placeholders, ", ")+`)
+			GROUP BY user_id
+		),
+		ranked AS (
+			SELECT u.id, u.username, COALESCE(u.avatar, '') AS avatar, u.created_at,
+			       CASE WHEN LOWER(u.username) = ? THEN 0
+			            WHEN LOWER(u.username) LIKE ? ESCAPE '\' THEN 1
+			            WHEN LOWER(u.username) LIKE ? ESCAPE '\' THEN 2
+			            ELSE 3 END AS tier,
+			       (SELECT COUNT(DISTINCT mf.id) FROM my_friends mf
+			        JOIN friends f ON f.status = 'accepted'
+			          AND ((f.user_id = mf.id AND f.friend_id = u.id) OR (f.user_id = u.id AND f.friend_id = mf.id))) AS mutual,
+			       c.shared * 1000 / (? + LENGTH(u.username) + 1 - c.shared) AS score
+			FROM candidates c
+			JOIN users u ON u.id = c.user_id
+			WHERE u.id != ? AND NOT COALESCE(u.is_disabled, FALSE)
+			  AND NOT EXISTS (SELECT 1 FROM friends b
+			                  WHERE b.status = 'blocked'
+			                    AND ((b.user_id = u.id AND b.friend_id = ?) OR (b.user_id = ? AND b.friend_id = u.id)))
+		)
+		SELECT id, username, avatar, created_at, tier, mutual, score
+		FROM ranked
+		WHERE (tier < 3 OR score >= ?) `+keyset+`
+		ORDER BY tier, mutual DESC, score DESC, id
+		LIMIT ?`,
+		args...,
+	)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	var users []models.UserSearchResult
+	for rows.Next() {
+		var user models.UserSearchResult
+		if err := rows.Scan(
+			&user.ID, &user.Username, &user.Avatar, &user.CreatedAt,
+			&user.Position.Tier, &user.MutualFriends, &user.Position.Score,
+		); err != nil {
+			return nil, false, err
+		}
+		user.Avatar = models.AvatarURL(user.ID, user.Avatar)
+		user.Position.MutualFriends = user.MutualFriends
+		user.Position.ID = user.ID
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	hasMore := len(users) > limit
+	if hasMore {
+		users = users[:limit]
+	}
+	return users, hasMore, nil
+}
@@ -0,0 +1,155 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"scuffedsnap/models"
+)
+
+func TestSearchMessages(t *testing.T) {
+	// User 1 talks with 2 and 3; group 1 holds user 1 only
+	s := newInboxStore(t, 3, 1, 0)
+	send := func(sender, receiver int64, content string) int64 {
+		t.Helper()
+		msg, err := s.CreateMessage(sender, receiver, content, "text", 0, "", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg.ID
+	}
+	search := func(userID int64, search models.MessageSearch) []int64 {
+		t.Helper()
+		if search.Limit == 0 {
+			search.Limit = 10
+		}
+		results, _, err := s.SearchMessages(userID, search)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := make([]int64, len(results))
+		for i, result := range results {
+			ids[i] = result.ID
+		}
+		return ids
+	}
+
+	pizza := send(1, 2, "pizza tonight?")
+	pizzaTwice := send(2, 1, "pizza pizza, always pizza")
+	other := send(3, 4, "pizza without user 1")
+	edited := send(1, 3, "burgers")
+	deleted := send(3, 1, "pizza then")
+	expiring := send(2, 1, "secret pizza")
+
+	if _, err := s.UpdateMessageContent(edited, "actually pizza"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteMessage(deleted); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.exec("UPDATE messages SET expires_at = ? WHERE id = ?", time.Now().Add(-time.Minute), expiring); err != nil {
+		t.Fatal(err)
+	}
+
+	got := search(1, models.MessageSearch{Query: "Pizza!"})
+	if len(got) != 3 || got[0] != pizzaTwice {
+		t.Fatalf("search for pizza returned %v, want %d first of 3", got, pizzaTwice)
+	}
+	for _, id := range got {
+		if id == other || id == deleted || id == expiring {
+			t.Fatalf("search returned message %d", id)
+		}
+	}
+
+	if got := search(1, models.MessageSearch{Query: "burgers"}); len(got) != 0 {
+		t.Fatalf("search matched edited-away content: %v", got)
+	}
+	if got := search(1, models.MessageSearch{Query: "pizza", WithUserID: 3}); len(got) != 1 || got[0] != edited {
+		t.Fatalf("search with user 3 returned %v, want [%d]", got, edited)
+	}
+	if got := search(1, models.MessageSearch{Query: "pizza", SenderID: 1}); len(got) != 2 {
+		t.Fatalf("search by sender 1 returned %v", got)
+	}
+	if got := search(1, models.MessageSearch{Query: "pizza tonight", WithUserID: 2}); len(got) != 1 || got[0] != pizza {
+		t.Fatalf("search for both terms returned %v, want [%d]", got, pizza)
+	}
+	if got := search(1, models.MessageSearch{Query: `" OR NEAR -`}); len(got) != 0 {
+		t.Fatalf("search for punctuation returned %v", got)
+	}
+
+	results, _, err := s.SearchMessages(1, models.MessageSearch{Query: "tonight", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || !strings.Contains(results[0].Highlight, "\x01tonight\x02") {
+		t.Fatalf("search for tonight returned %+v", results)
+	}
+}
+
+func TestSearchUsers(t *testing.T) {
+	s := newInboxStore(t, 0, 0, 0)
+	create := func(username string) int64 {
+		t.Helper()
+		user, err := s.CreateUserWithAuth(username, username+"@example.com", "x", "email")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return user.ID
+	}
+	searcher := create("searcher")
+	mia := create("mia")
+	miaFan := create("mia_fan")
+	friendly := create("mia_friendly")
+	samia := create("samia")
+	amira := create("amira")
+	create("mai")
+	disabled := create("mia_disabled")
+	blocked := create("mia_blocked")
+	create("zed")
+	friend := create("friend")
+
+	if _, err := s.exec(
+		`INSERT INTO friends (user_id, friend_id, status) VALUES (?, ?, 'accepted'), (?, ?, 'accepted'), (?, ?, 'blocked')`,
+		searcher, friend, friendly, friend, blocked, searcher,
+	); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.exec("UPDATE users SET is_disabled = TRUE WHERE id = ?", disabled); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []int64
+	var after *models.UserSearchCursor
+	for {
+		users, hasMore, err := s.SearchUsers("MIA", searcher, 2, after)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, user := range users {
+			if user.Email != "" {
+				t.Fatalf("search returned %s's email", user.Username)
+			}
+			got = append(got, user.ID)
+		}
+		if !hasMore {
+			break
+		}
+		after = &users[len(users)-1].Position
+	}
+
+	// Exact, then prefix (more mutual friends first), then contains
+	want := []int64{mia, friendly, miaFan, samia}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("search for MIA returned %v, want %v", got, want)
+	}
+
+	users, _, err := s.SearchUsers("amirah", searcher, 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].ID != amira {
+		t.Fatalf("fuzzy search returned %+v, want amira", users)
+	}
+}
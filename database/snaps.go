@@ -0,0 +1,83 @@
+package database
+
+import "scuffedsnap/models"
+
+// Snap queries
+
+// CreateSnap sends a view-once snap whose content is a media ID. viewSeconds
+// of 0 means the snap is destroyed as soon as it is opened.
+func (s *sqlStore) CreateSnap(senderID, receiverID int64, mediaID string, viewSeconds int, clientID string) (*models.Message, error) {
+	var seconds interface{}
+	if viewSeconds > 0 {
+		seconds = viewSeconds
+	}
+
+	var id int64
+	err := s.inTx(func(tx *sqlTx) error {
+		if err := checkNotBlocked(tx, senderID, receiverID); err != nil {
+			return err
+		}
+		var err error
+		id, err = tx.insert(
+			"INSERT INTO messages (sender_id, receiver_id, content, type, view_seconds, client_id) VALUES (?, ?, ?, 'snap', ?, ?)",
+			senderID, receiverID, mediaID, seconds, nullIfEmpty(clientID),
+		)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetMessageByID(id)
+}
+
+// OpenSnap records the recipient's first open of a snap. It reports false
+// if the snap had already been opened.
+func (s *sqlStore) OpenSnap(id, receiverID int64) (bool, error) {
+	openedAt := now()
+	result, err := s.exec(
+		"UPDATE messages SET opened_at = ?, read_at = COALESCE(read_at, ?), delivered_at = COALESCE(delivered_at, ?) WHERE id = ? AND receiver_id = ? AND type = 'snap' AND opened_at IS NULL",
+		openedAt, openedAt, openedAt, id, receiverID,
+	)
+	if err != nil {
+		return false, err
+	}
+	rows, _ := result.RowsAffected()
+	return rows > 0, nil
+}
+
+// ClearSnap destroys an opened snap's content, leaving the message behind as
+// a record that it was sent and opened
+func (s *sqlStore) ClearSnap(id int64) error {
+	return s.inTx(func(tx *sqlTx) error {
+		if _, err := tx.exec("UPDATE messages SET content = '' WHERE id = ? AND type = 'snap'", id); err != nil {
+			return err
+		}
+		if _, err := tx.exec("DELETE FROM media WHERE message_id = ?", id); err != nil {
+			return err
+		}
+		// Don't replay the snap's media ID to clients that reconnect later
+		_, err := tx.exec("DELETE FROM user_events WHERE message_id = ?", id)
+		return err
+	})
+}
+
+// GetOpenedSnaps lists opened snaps whose content has not been destroyed yet
+func (s *sqlStore) GetOpenedSnaps() ([]models.Message, error) {
+	rows, err := s.query(
+		"SELECT " + messageColumns + " FROM messages m WHERE m.type = 'snap' AND m.opened_at IS NOT NULL AND m.content != ''",
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var snaps []models.Message
+	for rows.Next() {
+		var msg models.Message
+		if err := scanMessage(rows, &msg); err != nil {
+			return nil, err
+		}
+		snaps = append(snaps, msg)
+	}
+	return snaps, rows.Err()
+}
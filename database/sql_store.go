@@ -0,0 +1,908 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"scuffedsnap/models"
+)
+
+// dialect captures the SQL differences between backends
+type dialect interface {
+	// rebind rewrites ? placeholders into the backend's bind syntax
+	rebind(query string) string
+	// name selects the migrations directory for the backend
+	name() string
+	// timestamp binds t for exact comparison with a column filled by the
+	// database's own current-time default
+	timestamp(t time.Time) interface{}
+	// messageSearch builds the backend's full-text search for the given terms
+	messageSearch(terms []string) textSearch
+}
+
+// sqlStore implements Store on top of database/sql for any dialect
+type sqlStore struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+const userColumns = "id, username, email, password, COALESCE(avatar, ''), created_at, COALESCE(auth_method, 'email'), COALESCE(is_disabled, FALSE), presence, last_seen_at, read_receipts"
+
+// messageColumns selects a models.Message from the messages table aliased as m
+const messageColumns = "m.id, m.sender_id, COALESCE(m.receiver_id, 0), COALESCE(m.conversation_id, 0), m.content, m.type, m.expires_at, m.read_at, m.created_at, COALESCE(m.edited, FALSE), m.updated_at, m.opened_at, COALESCE(m.view_seconds, 0), COALESCE(m.disappear_seconds, 0), COALESCE(m.client_id, ''), m.delivered_at, COALESCE(m.reply_to_id, 0)"
+
+// scanner is satisfied by *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanMessage scans messageColumns into msg, followed by any extra columns
+func scanMessage(row scanner, msg *models.Message, extra ...interface{}) error {
+	dest := []interface{}{
+		&msg.ID, &msg.SenderID, &msg.ReceiverID, &msg.ConversationID, &msg.Content, &msg.Type,
+		&msg.ExpiresAt, &msg.ReadAt, optionalTime{&msg.CreatedAt}, &msg.Edited, &msg.UpdatedAt,
+		&msg.OpenedAt, &msg.ViewSeconds, &msg.DisappearSeconds, &msg.ClientID, &msg.DeliveredAt,
+		&msg.ReplyToID,
+	}
+	return row.Scan(append(dest, extra...)...)
+}
+
+// scanMessageWithSender scans messageColumns followed by the sender's username
+// and avatar, then any extra columns. Senders without an avatar get their
+// identicon.
+func scanMessageWithSender(row scanner, msg *models.MessageWithSender, extra ...interface{}) error {
+	dest := append([]interface{}{&msg.SenderUsername, &msg.SenderAvatar}, extra...)
+	if err := scanMessage(row, &msg.Message, dest...); err != nil {
+		return err
+	}
+	msg.SenderAvatar = models.AvatarURL(msg.SenderID, msg.SenderAvatar)
+	return nil
+}
+
+// optionalTime scans a timestamp that is NULL when read through an outer join
+// that matched nothing, leaving the destination zero
+type optionalTime struct {
+	dest *time.Time
+}
+
+func (o optionalTime) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case time.Time:
+		*o.dest = v
+		return nil
+	}
+	return fmt.Errorf("cannot scan %T into time.Time", src)
+}
+
+// inList builds a parenthesized placeholder list and its arguments for an IN clause
+func inList(ids []int64) (string, []interface{}) {
+	placeholders := make([]string, len(ids))
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		placeholders[i] = "?"
+		args[i] = id
+	}
+	return "(" + strings.Join(placeholders, ", ") + ")", args
+}
+
+// nullIfEmpty stores empty strings as NULL
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// nullIfZero stores zero IDs as NULL
+func nullIfZero(id int64) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+// now returns the current time in UTC so stored timestamps compare consistently
+func now() time.Time {
+	return time.Now().UTC()
+}
+
+func (s *sqlStore) exec(query string, args ...interface{}) (sql.Result, error) {
+	return s.db.Exec(s.dialect.rebind(query), args...)
+}
+
+func (s *sqlStore) query(query string, args ...interface{}) (*sql.Rows, error) {
+	return s.db.Query(s.dialect.rebind(query), args...)
+}
+
+func (s *sqlStore) queryRow(query string, args ...interface{}) *sql.Row {
+	return s.db.QueryRow(s.dialect.rebind(query), args...)
+}
+
+// insert runs an INSERT ... RETURNING id and returns the new row's ID
+func (s *sqlStore) insert(query string, args ...interface{}) (int64, error) {
+	var id int64
+	err := s.queryRow(query+" RETURNING id", args...).Scan(&id)
+	return id, err
+}
+
+// sqlTx is a transaction that rebinds placeholders like sqlStore
+type sqlTx struct {
+	tx      *sql.Tx
+	dialect dialect
+}
+
+func (t *sqlTx) exec(query string, args ...interface{}) (sql.Result, error) {
+	return t.tx.Exec(t.dialect.rebind(query), args...)
+}
+
+func (t *sqlTx) query(query string, args ...interface{}) (*sql.Rows, error) {
+	return t.tx.Query(t.dialect.rebind(query), args...)
+}
+
+func (t *sqlTx) queryRow(query string, args ...interface{}) *sql.Row {
+	return t.tx.QueryRow(t.dialect.rebind(query), args...)
+}
+
+func (t *sqlTx) insert(query string, args ...interface{}) (int64, error) {
+	var id int64
+	err := t.queryRow(query+" RETURNING id", args...).Scan(&id)
+	return id, err
+}
+
+// inTx runs fn in a transaction, committing only if it returns nil
+func (s *sqlStore) inTx(fn func(tx *sqlTx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(&sqlTx{tx: tx, dialect: s.dialect}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Close closes the underlying connection pool
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+// User queries
+
+// CreateUserWithAuth inserts a new user with auth method tracking and indexes
+// their username for search
+func (s *sqlStore) CreateUserWithAuth(username, email, password, authMethod string) (*models.User, error) {
+	var id int64
+	err := s.inTx(func(tx *sqlTx) error {
+		var err error
+		id, err = tx.insert(
+			"INSERT INTO users (username, email, password, auth_method) VALUES (?, ?, ?, ?)",
+			username, email, password, authMethod,
+		)
+		if err != nil {
+			return err
+		}
+		return indexUsername(tx, id, username)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetUserByID(id)
+}
+
+func (s *sqlStore) getUserWhere(where string, arg interface{}) (*models.User, error) {
+	user := &models.User{}
+	err := s.queryRow(
+		"SELECT "+userColumns+" FROM users WHERE "+where,
+		arg,
+	).Scan(&user.ID, &user.Username, &user.Email, &user.Password, &user.Avatar, &user.CreatedAt, &user.AuthMethod, &user.IsDisabled, &user.Presence, &user.LastSeenAt, &user.ReadReceipts)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// GetUserByID retrieves a user by their ID
+func (s *sqlStore) GetUserByID(id int64) (*models.User, error) {
+	return s.getUserWhere("id = ?", id)
+}
+
+// GetUserByUsername retrieves a user by their username
+func (s *sqlStore) GetUserByUsername(username string) (*models.User, error) {
+	return s.getUserWhere("username = ?", username)
+}
+
+// GetUserByEmail retrieves a user by their email
+func (s *sqlStore) GetUserByEmail(email string) (*models.User, error) {
+	return s.getUserWhere("email = ?", email)
+}
+
+// UpdateUserAvatar sets a user's avatar URL
+func (s *sqlStore) UpdateUserAvatar(userID int64, avatar string) error {
+	_, err := s.exec("UPDATE users SET avatar = ? WHERE id = ?", avatar, userID)
+	return err
+}
+
+// SetUserPresence stores the presence state a user chose
+func (s *sqlStore) SetUserPresence(userID int64, presence models.Presence) error {
+	_, err := s.exec("UPDATE users SET presence = ? WHERE id = ?", string(presence), userID)
+	return err
+}
+
+// TouchLastSeen records that a user was connected at the given time. Invisible
+// users keep the last_seen_at from before they went invisible.
+func (s *sqlStore) TouchLastSeen(userID int64, at time.Time) error {
+	_, err := s.exec(
+		"UPDATE users SET last_seen_at = ? WHERE id = ? AND presence != ?",
+		at.UTC(), userID, string(models.PresenceInvisible),
+	)
+	return err
+}
+
+// GetUserPresence returns the stored presence of each of the given users
+func (s *sqlStore) GetUserPresence(userIDs []int64) (map[int64]models.UserPresence, error) {
+	presence := make(map[int64]models.UserPresence, len(userIDs))
+	if len(userIDs) == 0 {
+		return presence, nil
+	}
+
+	in, args := inList(userIDs)
+	rows, err := s.query("SELECT id, presence, last_seen_at FROM users WHERE id IN "+in, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p models.UserPresence
+		if err := rows.Scan(&p.UserID, &p.Presence, &p.LastSeen); err != nil {
+			return nil, err
+		}
+		presence[p.UserID] = p
+	}
+	return presence, rows.Err()
+}
+
+// Session queries
+
+// CreateSession creates a new session for a user
+func (s *sqlStore) CreateSession(sessionID string, userID int64, expiresAt time.Time) error {
+	_, err := s.exec(
+		"INSERT INTO sessions (id, user_id, expires_at) VALUES (?, ?, ?)",
+		sessionID, userID, expiresAt.UTC(),
+	)
+	return err
+}
+
+// GetSession retrieves a session by its ID
+func (s *sqlStore) GetSession(sessionID string) (*models.Session, error) {
+	session := &models.Session{}
+	err := s.queryRow(
+		"SELECT id, user_id, created_at, expires_at FROM sessions WHERE id = ? AND expires_at > ?",
+		sessionID, now(),
+	).Scan(&session.ID, &session.UserID, &session.CreatedAt, &session.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// DeleteSession removes a session
+func (s *sqlStore) DeleteSession(sessionID string) error {
+	_, err := s.exec("DELETE FROM sessions WHERE id = ?", sessionID)
+	return err
+}
+
+// DeleteUserSessions removes all sessions for a user
+func (s *sqlStore) DeleteUserSessions(userID int64) error {
+	_, err := s.exec("DELETE FROM sessions WHERE user_id = ?", userID)
+	return err
+}
+
+// Message queries
+
+// CreateMessage creates a new message. A positive disappearAfter makes it
+// expire that long after the receiver reads it; a non-empty clientID must be
+// unique for the sender. A non-zero replyToID records the message it quotes.
+func (s *sqlStore) CreateMessage(senderID, receiverID int64, content, msgType string, disappearAfter time.Duration, clientID string, replyToID int64) (*models.Message, error) {
+	var disappearSeconds interface{}
+	if disappearAfter > 0 {
+		disappearSeconds = int64(disappearAfter / time.Second)
+	}
+
+	var id int64
+	err := s.inTx(func(tx *sqlTx) error {
+		if err := checkNotBlocked(tx, senderID, receiverID); err != nil {
+			return err
+		}
+		var err error
+		id, err = tx.insert(
+			"INSERT INTO messages (sender_id, receiver_id, content, type, disappear_seconds, client_id, reply_to_id) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			senderID, receiverID, content, msgType, disappearSeconds, nullIfEmpty(clientID), nullIfZero(replyToID),
+		)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetMessageByID(id)
+}
+
+// GetMessageByID retrieves a message by its ID
+func (s *sqlStore) GetMessageByID(id int64) (*models.Message, error) {
+	msg := &models.Message{}
+	err := scanMessage(s.queryRow(
+		"SELECT "+messageColumns+" FROM messages m WHERE m.id = ?",
+		id,
+	), msg)
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// GetMessageByClientID finds a message by its sender and client-generated ID
+func (s *sqlStore) GetMessageByClientID(senderID int64, clientID string) (*models.Message, error) {
+	msg := &models.Message{}
+	err := scanMessage(s.queryRow(
+		"SELECT "+messageColumns+" FROM messages m WHERE m.sender_id = ? AND m.client_id = ?",
+		senderID, clientID,
+	), msg)
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// GetMessagesBetweenUsers retrieves a page of the history between two users in
+// chronological order, reporting whether more messages lie beyond it in the
+// direction paged. Each direction of the pair is read through its own index
+// range, so the cost depends on the page size rather than the scroll depth.
+func (s *sqlStore) GetMessagesBetweenUsers(userID1, userID2 int64, page models.Page) ([]models.MessageWithSender, bool, error) {
+	bound, order := "", "DESC"
+	var boundArgs []interface{}
+	if page.After != nil {
+		bound, order = "AND (created_at, id) > (?, ?)", "ASC"
+		boundArgs = []interface{}{s.dialect.timestamp(page.After.Time), page.After.ID}
+	} else if page.Before != nil {
+		bound = "AND (created_at, id) < (?, ?)"
+		boundArgs = []interface{}{s.dialect.timestamp(page.Before.Time), page.Before.ID}
+	}
+
+	// One extra row tells whether there is more
+	limit := page.Limit + 1
+	direction := `SELECT * FROM (
+		SELECT * FROM messages
+		WHERE sender_id = ? AND receiver_id = ? AND (expires_at IS NULL OR expires_at > ?) ` + bound + `
+		ORDER BY created_at ` + order + `, id ` + order + `
+		LIMIT ?) AS page`
+
+	var args []interface{}
+	for _, pair := range [][2]int64{{userID1, userID2}, {userID2, userID1}} {
+		args = append(args, pair[0], pair[1], now())
+		args = append(args, boundArgs...)
+		args = append(args, limit)
+	}
+	args = append(args, limit)
+
+	// UNION rather than UNION ALL so a chat with yourself isn't doubled
+	rows, err := s.query(
+		`SELECT `+messageColumns+`, u.username, COALESCE(u.avatar, '')
+		FROM (`+direction+` UNION `+direction+`) m
+		JOIN users u ON m.sender_id = u.id
+		ORDER BY m.created_at `+order+`, m.id `+order+`
+		LIMIT ?`,
+		args...,
+	)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	var messages []models.MessageWithSender
+	for rows.Next() {
+		var msg models.MessageWithSender
+		if err := scanMessageWithSender(rows, &msg); err != nil {
+			return nil, false, err
+		}
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	hasMore := len(messages) > page.Limit
+	if hasMore {
+		messages = messages[:page.Limit]
+	}
+	if order == "DESC" {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+	if err := s.annotateMessages(messages); err != nil {
+		return nil, false, err
+	}
+	return messages, hasMore, nil
+}
+
+// scanMessagesWithSender reads newest-first rows and returns them in chronological order
+func scanMessagesWithSender(rows *sql.Rows) ([]models.MessageWithSender, error) {
+	defer rows.Close()
+
+	var messages []models.MessageWithSender
+	for rows.Next() {
+		var msg models.MessageWithSender
+		if err := scanMessageWithSender(rows, &msg); err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Reverse to get chronological order
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+
+	return messages, nil
+}
+
+// UpdateMessageContent replaces a message's content and records the edit time
+func (s *sqlStore) UpdateMessageContent(id int64, content string) (*models.Message, error) {
+	result, err := s.exec(
+		"UPDATE messages SET content = ?, edited = TRUE, updated_at = ? WHERE id = ?",
+		content, now(), id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return s.GetMessageByID(id)
+}
+
+// DeleteMessage permanently removes a message along with its media row
+func (s *sqlStore) DeleteMessage(id int64) error {
+	return s.inTx(func(tx *sqlTx) error {
+		// Drop the upload too, so it can't be attached to another message
+		if _, err := tx.exec("DELETE FROM media WHERE message_id = ?", id); err != nil {
+			return err
+		}
+		result, err := tx.exec("DELETE FROM messages WHERE id = ?", id)
+		if err != nil {
+			return err
+		}
+		if rows, _ := result.RowsAffected(); rows == 0 {
+			return sql.ErrNoRows
+		}
+		return nil
+	})
+}
+
+// MarkMessagesAsRead marks unread messages from a sender to receiver as read,
+// up to and including upToID when it is non-zero, and starts the countdown on
+// any disappearing ones among them. The receipt names the messages changed.
+func (s *sqlStore) MarkMessagesAsRead(senderID, receiverID, upToID int64) (*models.Receipt, error) {
+	receipt := &models.Receipt{SenderID: senderID, ReceiverID: receiverID, At: now()}
+	err := s.inTx(func(tx *sqlTx) error {
+		where := "sender_id = ? AND receiver_id = ? AND read_at IS NULL"
+		args := []interface{}{senderID, receiverID}
+		if upToID > 0 {
+			where += " AND id <= ?"
+			args = append(args, upToID)
+		}
+		rows, err := tx.query("SELECT id FROM messages WHERE "+where+" ORDER BY id", args...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			receipt.MessageIDs = append(receipt.MessageIDs, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(receipt.MessageIDs) == 0 {
+			return nil
+		}
+
+		// The updates reuse the predicate rather than listing IDs, so a
+		// sender with any number of unread messages can't exceed the
+		// driver's parameter limit
+		rows, err = tx.query("SELECT DISTINCT disappear_seconds FROM messages WHERE "+where+" AND disappear_seconds > 0", args...)
+		if err != nil {
+			return err
+		}
+		var timers []int64
+		for rows.Next() {
+			var seconds int64
+			if err := rows.Scan(&seconds); err != nil {
+				rows.Close()
+				return err
+			}
+			timers = append(timers, seconds)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, seconds := range timers {
+			if _, err := tx.exec(
+				"UPDATE messages SET expires_at = ? WHERE "+where+" AND disappear_seconds = ?",
+				append(append([]interface{}{receipt.At.Add(time.Duration(seconds) * time.Second)}, args...), seconds)...,
+			); err != nil {
+				return err
+			}
+		}
+
+		// Reading a message implies it was delivered
+		_, err = tx.exec(
+			"UPDATE messages SET read_at = ?, delivered_at = COALESCE(delivered_at, ?) WHERE "+where,
+			append([]interface{}{receipt.At, receipt.At}, args...)...,
+		)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return receipt, nil
+}
+
+// DeleteExpiredMessages removes up to limit expired messages, along with the
+// media they reference, and returns what was removed
+func (s *sqlStore) DeleteExpiredMessages(limit int) ([]models.Message, error) {
+	return s.deleteMessagesWhere("m.expires_at IS NOT NULL AND m.expires_at < ?", []interface{}{now()}, limit)
+}
+
+// deleteMessagesWhere removes up to limit messages matching a condition on
+// the m alias, along with their media rows, in one short transaction
+func (s *sqlStore) deleteMessagesWhere(where string, args []interface{}, limit int) ([]models.Message, error) {
+	var deleted []models.Message
+	err := s.inTx(func(tx *sqlTx) error {
+		rows, err := tx.query(
+			"SELECT "+messageColumns+" FROM messages m WHERE "+where+" ORDER BY m.id LIMIT ?",
+			append(args, limit)...,
+		)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var msg models.Message
+			if err := scanMessage(rows, &msg); err != nil {
+				rows.Close()
+				return err
+			}
+			deleted = append(deleted, msg)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(deleted) == 0 {
+			return nil
+		}
+
+		messageIDs := make([]int64, len(deleted))
+		for i, msg := range deleted {
+			messageIDs[i] = msg.ID
+		}
+		in, ids := inList(messageIDs)
+
+		if _, err := tx.exec("DELETE FROM media WHERE message_id IN "+in, ids...); err != nil {
+			return err
+		}
+		_, err = tx.exec("DELETE FROM messages WHERE id IN "+in, ids...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
+
+// Friend queries
+
+// livePending excludes pending requests that have expired, binding now once
+const livePending = "(f.status != 'pending' OR f.expires_at IS NULL OR f.expires_at > ?)"
+
+// CreateFriendRequest creates a friend request that lapses at expiresAt
+func (s *sqlStore) CreateFriendRequest(userID, friendID int64, expiresAt time.Time) error {
+	return s.inTx(func(tx *sqlTx) error {
+		if err := checkNotBlocked(tx, userID, friendID); err != nil {
+			return err
+		}
+		// Clear out a lapsed request between the pair so it can be re-sent
+		if _, err := tx.exec(
+			`DELETE FROM friends
+			WHERE ((user_id = ? AND friend_id = ?) OR (user_id = ? AND friend_id = ?))
+			  AND status = 'pending' AND expires_at IS NOT NULL AND expires_at <= ?`,
+			userID, friendID, friendID, userID, now(),
+		); err != nil {
+			return err
+		}
+		_, err := tx.exec(
+			"INSERT INTO friends (user_id, friend_id, status, expires_at) VALUES (?, ?, 'pending', ?)",
+			userID, friendID, expiresAt.UTC(),
+		)
+		return err
+	})
+}
+
+// GetFriendship retrieves a friendship record, ignoring expired requests
+func (s *sqlStore) GetFriendship(userID, friendID int64) (*models.Friend, error) {
+	friend := &models.Friend{}
+	err := s.queryRow(
+		`SELECT f.id, f.user_id, f.friend_id, f.status, f.created_at, f.expires_at FROM friends f
+		WHERE ((f.user_id = ? AND f.friend_id = ?) OR (f.user_id = ? AND f.friend_id = ?))
+		  AND `+livePending,
+		userID, friendID, friendID, userID, now(),
+	).Scan(&friend.ID, &friend.UserID, &friend.FriendID, &friend.Status, &friend.CreatedAt, &friend.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return friend, nil
+}
+
+// GetFriendRequest retrieves a pending, unexpired friend request by ID
+func (s *sqlStore) GetFriendRequest(requestID int64) (*models.Friend, error) {
+	friend := &models.Friend{}
+	err := s.queryRow(
+		`SELECT f.id, f.user_id, f.friend_id, f.status, f.created_at, f.expires_at FROM friends f
+		WHERE f.id = ? AND f.status = 'pending' AND `+livePending,
+		requestID, now(),
+	).Scan(&friend.ID, &friend.UserID, &friend.FriendID, &friend.Status, &friend.CreatedAt, &friend.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return friend, nil
+}
+
+// AcceptFriendRequest accepts a pending friend request
+func (s *sqlStore) AcceptFriendRequest(requestID int64, userID int64) error {
+	result, err := s.exec(
+		`UPDATE friends SET status = 'accepted', expires_at = NULL
+		WHERE id = ? AND friend_id = ? AND status = 'pending' AND (expires_at IS NULL OR expires_at > ?)`,
+		requestID, userID, now(),
+	)
+	if err != nil {
+		return err
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeclineFriendRequest deletes a pending request sent to userID
+func (s *sqlStore) DeclineFriendRequest(requestID int64, userID int64) error {
+	return s.deletePendingRequest("id = ? AND friend_id = ?", requestID, userID)
+}
+
+// CancelFriendRequest withdraws a pending request sent by userID
+func (s *sqlStore) CancelFriendRequest(requestID int64, userID int64) error {
+	return s.deletePendingRequest("id = ? AND user_id = ?", requestID, userID)
+}
+
+func (s *sqlStore) deletePendingRequest(where string, requestID, userID int64) error {
+	result, err := s.exec(
+		"DELETE FROM friends WHERE "+where+" AND status = 'pending' AND (expires_at IS NULL OR expires_at > ?)",
+		requestID, userID, now(),
+	)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteExpiredFriendRequests removes pending requests past their expiry and
+// returns them
+func (s *sqlStore) DeleteExpiredFriendRequests() ([]models.Friend, error) {
+	rows, err := s.query(
+		`DELETE FROM friends WHERE status = 'pending' AND expires_at IS NOT NULL AND expires_at <= ?
+		RETURNING id, user_id, friend_id, status, created_at, expires_at`,
+		now(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var expired []models.Friend
+	for rows.Next() {
+		var f models.Friend
+		if err := rows.Scan(&f.ID, &f.UserID, &f.FriendID, &f.Status, &f.CreatedAt, &f.ExpiresAt); err != nil {
+			return nil, err
+		}
+		expired = append(expired, f)
+	}
+	return expired, rows.Err()
+}
+
+// GetFriends retrieves all accepted friends for a user
+func (s *sqlStore) GetFriends(userID int64) ([]models.UserResponse, error) {
+	rows, err := s.query(
+		`SELECT u.id, u.username, u.email, COALESCE(u.avatar, ''), u.created_at
+		FROM users u
+		JOIN friends f ON (f.user_id = u.id OR f.friend_id = u.id)
+		WHERE ((f.user_id = ? OR f.friend_id = ?) AND f.status = 'accepted')
+		  AND u.id != ?`,
+		userID, userID, userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var friends []models.UserResponse
+	seen := make(map[int64]bool)
+	for rows.Next() {
+		var user models.UserResponse
+		if err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.Avatar, &user.CreatedAt); err != nil {
+			return nil, err
+		}
+		user.Avatar = models.AvatarURL(user.ID, user.Avatar)
+		if !seen[user.ID] {
+			friends = append(friends, user)
+			seen[user.ID] = true
+		}
+	}
+	return friends, rows.Err()
+}
+
+// GetFriendIDs returns the IDs of a user's accepted friends
+func (s *sqlStore) GetFriendIDs(userID int64) ([]int64, error) {
+	rows, err := s.query(
+		`SELECT CASE WHEN user_id = ? THEN friend_id ELSE user_id END
+		FROM friends
+		WHERE (user_id = ? OR friend_id = ?) AND status = 'accepted'`,
+		userID, userID, userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// GetPendingFriendRequests retrieves pending friend requests for a user
+func (s *sqlStore) GetPendingFriendRequests(userID int64) ([]models.FriendRequest, error) {
+	rows, err := s.query(
+		`SELECT f.id, u.id, u.username, u.email, COALESCE(u.avatar, ''), u.created_at, f.status, f.created_at, f.expires_at
+		FROM friends f
+		JOIN users u ON f.user_id = u.id
+		WHERE f.friend_id = ? AND f.status = 'pending' AND `+livePending+`
+		ORDER BY f.created_at DESC`,
+		userID, now(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var requests []models.FriendRequest
+	for rows.Next() {
+		var req models.FriendRequest
+		if err := rows.Scan(
+			&req.ID, &req.From.ID, &req.From.Username, &req.From.Email,
+			&req.From.Avatar, &req.From.CreatedAt, &req.Status, &req.CreatedAt, &req.ExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+		req.From.Avatar = models.AvatarURL(req.From.ID, req.From.Avatar)
+		requests = append(requests, req)
+	}
+	return requests, rows.Err()
+}
+
+// GetOutgoingFriendRequests retrieves the pending requests a user has sent
+func (s *sqlStore) GetOutgoingFriendRequests(userID int64) ([]models.OutgoingFriendRequest, error) {
+	rows, err := s.query(
+		`SELECT f.id, u.id, u.username, COALESCE(u.avatar, ''), u.created_at, f.status, f.created_at, f.expires_at
+		FROM friends f
+		JOIN users u ON f.friend_id = u.id
+		WHERE f.user_id = ? AND f.status = 'pending' AND `+livePending+`
+		ORDER BY f.created_at DESC`,
+		userID, now(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var requests []models.OutgoingFriendRequest
+	for rows.Next() {
+		var req models.OutgoingFriendRequest
+		if err := rows.Scan(
+			&req.ID, &req.To.ID, &req.To.Username, &req.To.Avatar, &req.To.CreatedAt,
+			&req.Status, &req.CreatedAt, &req.ExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+		req.To.Avatar = models.AvatarURL(req.To.ID, req.To.Avatar)
+		requests = append(requests, req)
+	}
+	return requests, rows.Err()
+}
+
+// DeleteFriend removes a friendship or pending request. Blocks are left in
+// place; only the blocker can lift one, through UnblockUser.
+func (s *sqlStore) DeleteFriend(userID, friendID int64) error {
+	_, err := s.exec(
+		`DELETE FROM friends
+		WHERE ((user_id = ? AND friend_id = ?) OR (user_id = ? AND friend_id = ?))
+		  AND status != 'blocked'`,
+		userID, friendID, friendID, userID,
+	)
+	return err
+}
+
+// Admin queries
+
+// GetAllUsers returns all users with their active session info
+func (s *sqlStore) GetAllUsers() ([]models.UserResponse, error) {
+	rows, err := s.query(`
+		SELECT u.id, u.username, u.email, COALESCE(u.avatar, ''), u.created_at,
+		       COALESCE(u.auth_method, 'email'), COALESCE(u.is_disabled, FALSE),
+		       EXISTS (SELECT 1 FROM sessions s WHERE s.user_id = u.id AND s.expires_at > ?) AS online
+		FROM users u
+		ORDER BY u.created_at DESC
+	`, now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.UserResponse
+	for rows.Next() {
+		var user models.UserResponse
+		err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.Avatar,
+			&user.CreatedAt, &user.AuthMethod, &user.IsDisabled, &user.Online)
+		if err != nil {
+			return nil, err
+		}
+		user.Avatar = models.AvatarURL(user.ID, user.Avatar)
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// DisableUser disables or enables a user account
+func (s *sqlStore) DisableUser(userID int64, disabled bool) error {
+	_, err := s.exec("UPDATE users SET is_disabled = ? WHERE id = ?", disabled, userID)
+	return err
+}
+
+// ResetUserPassword resets a user's password
+func (s *sqlStore) ResetUserPassword(userID int64, newPassword string) error {
+	_, err := s.exec("UPDATE users SET password = ? WHERE id = ?", newPassword, userID)
+	return err
+}
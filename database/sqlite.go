@@ -0,0 +1,118 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqliteDriver is go-sqlite3 with the SQL functions the store relies on
+const sqliteDriver = "sqlite3_scuffedsnap"
+
+func init() {
+	sql.Register(sqliteDriver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("fts_rank", ftsRank, true)
+		},
+	})
+}
+
+// SQLiteStore is a Store backed by a single SQLite database file
+type SQLiteStore struct {
+	sqlStore
+}
+
+// NewSQLiteStore opens (or creates) the SQLite database at path
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	db, err := sql.Open(sqliteDriver, "file:"+path+"?_foreign_keys=on&_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	// SQLite allows a single writer; serialize access through one connection
+	db.SetMaxOpenConns(1)
+
+	return &SQLiteStore{sqlStore{db: db, dialect: sqliteDialect{}}}, nil
+}
+
+type sqliteDialect struct{}
+
+// rebind is a no-op: SQLite understands ? placeholders natively
+func (sqliteDialect) rebind(query string) string {
+	return query
+}
+
+func (sqliteDialect) name() string {
+	return "sqlite"
+}
+
+// timestamp matches CURRENT_TIMESTAMP's text format, which sorts differently
+// from the driver's own encoding of time.Time
+func (sqliteDialect) timestamp(t time.Time) interface{} {
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
+
+// messageSearch matches the quoted terms against the FTS4 index, ranked by
+// fts_rank over its match statistics
+func (sqliteDialect) messageSearch(terms []string) textSearch {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + term + `"`
+	}
+	return textSearch{
+		from:      "messages_fts JOIN messages m ON m.id = messages_fts.docid",
+		match:     "messages_fts MATCH ?",
+		highlight: "snippet(messages_fts, char(1), char(2), '…', -1, 24)",
+		rank:      "fts_rank(matchinfo(messages_fts, 'pcnalx'))",
+		arg:       strings.Join(quoted, " "),
+	}
+}
+
+// BM25 tuning used by ftsRank
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// ftsRank scores an FTS4 row with Okapi BM25 from matchinfo(..., 'pcnalx'),
+// higher meaning more relevant. FTS4 has no ranking function of its own.
+func ftsRank(matchinfo []byte) float64 {
+	info := make([]uint32, len(matchinfo)/4)
+	for i := range info {
+		info[i] = binary.NativeEndian.Uint32(matchinfo[i*4:])
+	}
+	if len(info) < 3 {
+		return 0
+	}
+	phrases, columns, rows := int(info[0]), int(info[1]), float64(info[2])
+	if len(info) < 3+2*columns+3*phrases*columns {
+		return 0
+	}
+	avgLength := info[3 : 3+columns]
+	length := info[3+columns : 3+2*columns]
+	hits := info[3+2*columns:]
+
+	var score float64
+	for p := 0; p < phrases; p++ {
+		for c := 0; c < columns; c++ {
+			x := 3 * (c + p*columns)
+			tf, docs := float64(hits[x]), float64(hits[x+2])
+			if tf == 0 {
+				continue
+			}
+			idf := math.Max(math.Log((rows-docs+0.5)/(docs+0.5)), 1e-6)
+			norm := 1 - bm25B + bm25B*float64(length[c])/math.Max(float64(avgLength[c]), 1)
+			score += idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+		}
+	}
+	return score
+}
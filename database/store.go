@@ -0,0 +1,124 @@
+package database
+
+import (
+	"time"
+
+	"scuffedsnap/models"
+)
+
+// Store is the storage backend used by the package-level query functions
+type Store interface {
+	// Users
+	CreateUserWithAuth(username, email, password, authMethod string) (*models.User, error)
+	GetUserByID(id int64) (*models.User, error)
+	GetUserByUsername(username string) (*models.User, error)
+	GetUserByEmail(email string) (*models.User, error)
+	UpdateUserAvatar(userID int64, avatar string) error
+	SetUserPresence(userID int64, presence models.Presence) error
+	TouchLastSeen(userID int64, at time.Time) error
+	GetUserPresence(userIDs []int64) (map[int64]models.UserPresence, error)
+	SearchUsers(query string, currentUserID int64, limit int, after *models.UserSearchCursor) ([]models.UserSearchResult, bool, error)
+
+	// Sessions
+	CreateSession(sessionID string, userID int64, expiresAt time.Time) error
+	GetSession(sessionID string) (*models.Session, error)
+	DeleteSession(sessionID string) error
+	DeleteUserSessions(userID int64) error
+
+	// Messages
+	CreateMessage(senderID, receiverID int64, content, msgType string, disappearAfter time.Duration, clientID string, replyToID int64) (*models.Message, error)
+	GetMessageByID(id int64) (*models.Message, error)
+	GetMessageByClientID(senderID int64, clientID string) (*models.Message, error)
+	GetMessagesBetweenUsers(userID1, userID2 int64, page models.Page) ([]models.MessageWithSender, bool, error)
+	GetConversations(userID int64, page models.Page) ([]models.Conversation, bool, error)
+	UpdateMessageContent(id int64, content string) (*models.Message, error)
+	DeleteMessage(id int64) error
+	MarkMessagesAsRead(senderID, receiverID, upToID int64) (*models.Receipt, error)
+	DeleteExpiredMessages(limit int) ([]models.Message, error)
+	SearchMessages(userID int64, search models.MessageSearch) ([]models.MessageSearchResult, bool, error)
+
+	// Receipts
+	MarkMessagesDelivered(receiverID int64, messageIDs []int64) ([]models.Receipt, error)
+	SetReadReceipts(userID int64, enabled bool) error
+	GetReadReceiptsDisabled(userIDs []int64) (map[int64]bool, error)
+
+	// Replies
+	FillReplyPreviews(messages ...*models.Message) error
+	GetThread(messageID int64, limit int) ([]models.MessageWithSender, bool, error)
+
+	// Reactions
+	AddReaction(messageID, userID int64, emoji string) (bool, error)
+	RemoveReaction(messageID, userID int64, emoji string) (bool, error)
+	GetReactions(messageIDs []int64) (map[int64][]models.ReactionSummary, error)
+
+	// Event log
+	AppendUserEvents(userIDs []int64, eventType, payload string, messageID int64) (map[int64]int64, error)
+	GetUserEvents(userID, afterSeq int64, limit int) ([]models.UserEvent, error)
+	GetUserEventCursor(userID int64) (latest, floor int64, err error)
+	PruneUserEvents(cutoff time.Time) (int64, error)
+
+	// Snaps
+	CreateSnap(senderID, receiverID int64, mediaID string, viewSeconds int, clientID string) (*models.Message, error)
+	OpenSnap(id, receiverID int64) (bool, error)
+	ClearSnap(id int64) error
+	GetOpenedSnaps() ([]models.Message, error)
+
+	// Group conversations
+	CreateGroup(creatorID int64, name, avatar string, memberIDs []int64) (*models.Group, error)
+	GetGroup(groupID int64) (*models.Group, error)
+	GetGroupMember(groupID, userID int64) (*models.GroupMember, error)
+	GetGroupMemberIDs(groupID int64) ([]int64, error)
+	UpdateGroup(groupID int64, name, avatar string) error
+	AddGroupMember(groupID, userID int64, role models.GroupRole) error
+	RemoveGroupMember(groupID, userID int64) error
+	SetGroupMemberRole(groupID, userID int64, role models.GroupRole) error
+	TransferGroupOwnership(groupID, fromID, toID int64) error
+	LeaveGroup(groupID, userID int64) (newOwnerID int64, deleted bool, removed []models.Message, err error)
+	CreateGroupMessage(senderID, groupID int64, content, msgType, clientID string, replyToID int64) (*models.Message, error)
+	GetGroupMessages(groupID int64, limit, offset int) ([]models.MessageWithSender, error)
+	MarkGroupAsRead(groupID, userID int64) error
+
+	// Media
+	CreateMedia(media *models.Media) error
+	GetMedia(id string) (*models.Media, error)
+	AttachMedia(id string, messageID int64) error
+
+	// Retention
+	SetRetentionOverride(o *models.RetentionOverride) error
+	DeleteRetentionOverride(o *models.RetentionOverride) error
+	GetRetentionOverrides() ([]models.RetentionOverride, error)
+	PurgeMessages(o *models.RetentionOverride, cutoff time.Time, limit int) ([]models.Message, error)
+	CountPurgeableMessages(o *models.RetentionOverride, cutoff time.Time) (int64, error)
+
+	// Friends
+	CreateFriendRequest(userID, friendID int64, expiresAt time.Time) error
+	GetFriendship(userID, friendID int64) (*models.Friend, error)
+	GetFriendRequest(requestID int64) (*models.Friend, error)
+	AcceptFriendRequest(requestID int64, userID int64) error
+	DeclineFriendRequest(requestID int64, userID int64) error
+	CancelFriendRequest(requestID int64, userID int64) error
+	DeleteExpiredFriendRequests() ([]models.Friend, error)
+	GetFriends(userID int64) ([]models.UserResponse, error)
+	GetFriendIDs(userID int64) ([]int64, error)
+	GetPendingFriendRequests(userID int64) ([]models.FriendRequest, error)
+	GetOutgoingFriendRequests(userID int64) ([]models.OutgoingFriendRequest, error)
+	DeleteFriend(userID, friendID int64) error
+
+	// Blocks
+	BlockUser(blockerID, blockedID int64) error
+	UnblockUser(blockerID, blockedID int64) error
+	GetBlockedUsers(userID int64) ([]models.UserResponse, error)
+	IsBlocked(userID1, userID2 int64) (bool, error)
+
+	// Admin
+	GetAllUsers() ([]models.UserResponse, error)
+	DisableUser(userID int64, disabled bool) error
+	ResetUserPassword(userID int64, newPassword string) error
+
+	// Schema migrations
+	MigrateUp() (int, error)
+	MigrateDown(steps int) (int, error)
+	MigrationStatus() ([]MigrationStatus, error)
+
+	Close() error
+}
@@ -187,7 +187,11 @@ func Me(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	json.NewEncoder(w).Encode(user.ToResponse())
+	// Your own chosen presence, including invisible, and privacy settings
+	resp := user.ToResponse()
+	resp.Status = user.Presence
+	resp.ReadReceipts = &user.ReadReceipts
+	json.NewEncoder(w).Encode(resp)
 }
 
 // GetSession returns the current session info
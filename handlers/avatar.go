@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"scuffedsnap/database"
+	"scuffedsnap/media"
+	"scuffedsnap/middleware"
+)
+
+// avatarURLPrefix is where uploaded avatars are served from
+const avatarURLPrefix = "/api/avatars/"
+
+// avatarKey is the blob key for one size of an avatar
+func avatarKey(id string, size int) string {
+	return id + "-" + strconv.Itoa(size)
+}
+
+// UploadAvatar replaces the current user's avatar with an uploaded PNG, JPEG
+// or GIF, stored as metadata-free square PNGs at each of media.AvatarSizes
+func UploadAvatar(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user := middleware.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	if mediaStore == nil {
+		http.Error(w, `{"error": "Media storage is not configured"}`, http.StatusServiceUnavailable)
+		return
+	}
+
+	data, ok := readUpload(w, r)
+	if !ok {
+		return
+	}
+
+	images, err := media.ProcessAvatar(data)
+	if errors.Is(err, media.ErrImageTooLarge) {
+		http.Error(w, `{"error": "Image dimensions are too large"}`, http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error": "Avatar must be a PNG, JPEG or GIF image"}`, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	id := media.NewID()
+	for size, img := range images {
+		if err := mediaStore.Put(avatarKey(id, size), bytes.NewReader(img)); err != nil {
+			deleteAvatar(id)
+			http.Error(w, `{"error": "Failed to store avatar"}`, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := database.UpdateUserAvatar(user.ID, avatarURLPrefix+id); err != nil {
+		deleteAvatar(id)
+		http.Error(w, `{"error": "Failed to update avatar"}`, http.StatusInternalServerError)
+		return
+	}
+
+	// Remove the previous upload now that nothing points at it
+	if oldID, ok := strings.CutPrefix(user.Avatar, avatarURLPrefix); ok {
+		deleteAvatar(oldID)
+	}
+
+	user.Avatar = avatarURLPrefix + id
+	json.NewEncoder(w).Encode(user.ToResponse())
+}
+
+// deleteAvatar removes every size of an uploaded avatar
+func deleteAvatar(id string) {
+	for _, size := range media.AvatarSizes {
+		mediaStore.Delete(avatarKey(id, size))
+	}
+}
+
+// GetAvatar serves an uploaded avatar. ?size= picks the smallest stored size
+// at least that large, defaulting to the largest.
+func GetAvatar(w http.ResponseWriter, r *http.Request) {
+	if mediaStore == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	size := media.AvatarSizes[0]
+	if requested, err := strconv.Atoi(r.URL.Query().Get("size")); err == nil {
+		for _, s := range media.AvatarSizes {
+			if s >= requested {
+				size = s
+			}
+		}
+	}
+
+	blob, err := mediaStore.Get(avatarKey(mux.Vars(r)["id"], size))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer blob.Close()
+
+	// Avatar IDs change on every upload, so the content never does
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	io.Copy(w, blob)
+}
+
+// GetIdenticon serves the generated fallback avatar for a user ID
+func GetIdenticon(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write(media.Identicon(strconv.FormatInt(userID, 10), media.AvatarSizes[0]))
+}
@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"scuffedsnap/broker"
+	"scuffedsnap/database"
+)
+
+const (
+	// presenceSyncInterval is how often this instance republishes its full
+	// set of online users so other instances can correct drift
+	presenceSyncInterval = 15 * time.Second
+	// presenceNodeTimeout drops an instance's users once it stops syncing
+	presenceNodeTimeout = 3 * presenceSyncInterval
+)
+
+// nodeID identifies this instance in broker events
+var nodeID = uuid.NewString()
+
+// bus carries hub events between instances; set with SetBroker
+var bus broker.Broker
+
+// presenceOut queues requests for a full presence sync
+var presenceOut = make(chan broker.Event, 1024)
+
+// presenceChange is a user's first device connecting or last device
+// disconnecting on this instance
+type presenceChange struct {
+	userID int64
+	online bool
+}
+
+// localChanges holds presence changes from the hub until runPresence applies
+// them, so the hub loop never waits on the database or the broker
+var localChanges = struct {
+	sync.Mutex
+	pending []presenceChange
+	ready   chan struct{}
+}{ready: make(chan struct{}, 1)}
+
+// remoteNode is the last known set of users online on another instance
+type remoteNode struct {
+	users map[int64]bool
+	seen  time.Time
+}
+
+// cluster tracks presence reported by other instances
+var cluster = struct {
+	sync.Mutex
+	nodes map[string]*remoteNode
+}{nodes: make(map[string]*remoteNode)}
+
+// SetBroker sets the broker that carries events between instances. Call it
+// before RunHub; without one RunHub falls back to an in-memory broker.
+func SetBroker(b broker.Broker) {
+	bus = b
+}
+
+// publish sends an event through the broker, or straight to this instance's
+// handler when no broker has been set
+func publish(event broker.Event) {
+	event.Node = nodeID
+	if bus == nil {
+		handleBrokerEvent(event)
+		return
+	}
+	if err := bus.Publish(event); err != nil {
+		log.Printf("Error publishing %s event: %v", event.Type, err)
+	}
+}
+
+// handleBrokerEvent applies an event received from any instance, this one included
+func handleBrokerEvent(event broker.Event) {
+	switch event.Type {
+	case broker.EventDeliver:
+		for _, userID := range event.UserIDs {
+			hub.broadcast <- BroadcastPayload{
+				UserID:       userID,
+				Message:      event.Data,
+				Seq:          event.Seq,
+				ExceptDevice: event.ExceptDevice,
+			}
+		}
+
+	case broker.EventPresence:
+		if event.Node == nodeID {
+			return
+		}
+		updateRemoteNode(event.Node, func(node *remoteNode) {
+			for _, userID := range event.UserIDs {
+				if event.Online {
+					node.users[userID] = true
+				} else {
+					delete(node.users, userID)
+				}
+			}
+		})
+
+	case broker.EventPresenceSync:
+		if event.Node == nodeID {
+			return
+		}
+		updateRemoteNode(event.Node, func(node *remoteNode) {
+			node.users = make(map[int64]bool, len(event.UserIDs))
+			for _, userID := range event.UserIDs {
+				node.users[userID] = true
+			}
+		})
+	}
+}
+
+// updateRemoteNode applies change to another instance's presence and tells
+// local clients about users whose cluster-wide status flipped
+func updateRemoteNode(id string, change func(*remoteNode)) {
+	cluster.Lock()
+	node, known := cluster.nodes[id]
+	if !known {
+		node = &remoteNode{users: make(map[int64]bool)}
+		cluster.nodes[id] = node
+	}
+	before := copyUserSet(node.users)
+	change(node)
+	node.seen = time.Now()
+	after := copyUserSet(node.users)
+	cluster.Unlock()
+
+	// A new instance learns everyone else's users from their next sync, so
+	// answer it straight away rather than waiting for the timer
+	if !known {
+		queuePresenceSync()
+	}
+
+	for userID := range before {
+		if !after[userID] && !IsUserOnline(userID) {
+			broadcastOnlineStatus(userID, false)
+		}
+	}
+	for userID := range after {
+		if !before[userID] && !isLocallyOnline(userID) && !onlineElsewhere(userID, id) {
+			broadcastOnlineStatus(userID, true)
+		}
+	}
+}
+
+func copyUserSet(users map[int64]bool) map[int64]bool {
+	out := make(map[int64]bool, len(users))
+	for userID := range users {
+		out[userID] = true
+	}
+	return out
+}
+
+// onlineElsewhere reports whether another instance, other than skipNode,
+// has userID connected
+func onlineElsewhere(userID int64, skipNode string) bool {
+	cluster.Lock()
+	defer cluster.Unlock()
+	for id, node := range cluster.nodes {
+		if id != skipNode && node.users[userID] {
+			return true
+		}
+	}
+	return false
+}
+
+// localPresenceChanged records that userID's first device connected or last
+// device disconnected on this instance. It runs on the hub goroutine, so it
+// only queues the change for runPresence.
+func localPresenceChanged(userID int64, online bool) {
+	localChanges.Lock()
+	localChanges.pending = append(localChanges.pending, presenceChange{userID: userID, online: online})
+	localChanges.Unlock()
+
+	select {
+	case localChanges.ready <- struct{}{}:
+	default:
+	}
+}
+
+// takePresenceChanges returns the queued presence changes in order and
+// empties the queue
+func takePresenceChanges() []presenceChange {
+	localChanges.Lock()
+	defer localChanges.Unlock()
+	changes := localChanges.pending
+	localChanges.pending = nil
+	return changes
+}
+
+// applyPresenceChange updates last seen, tells friends when the user's
+// cluster-wide status flipped and tells other instances
+func applyPresenceChange(change presenceChange) {
+	if err := database.TouchLastSeen(change.userID, time.Now()); err != nil {
+		log.Printf("Error updating last seen: %v", err)
+	}
+	if !onlineElsewhere(change.userID, "") {
+		broadcastOnlineStatus(change.userID, change.online)
+	}
+	publish(broker.Event{
+		Type:    broker.EventPresence,
+		UserIDs: []int64{change.userID},
+		Online:  change.online,
+	})
+}
+
+// queuePresenceSync asks runPresence to publish a full sync soon
+func queuePresenceSync() {
+	select {
+	case presenceOut <- broker.Event{Type: broker.EventPresenceSync}:
+	default:
+	}
+}
+
+// runPresence applies and publishes this instance's presence changes in
+// order, resyncs on a timer and forgets instances that have gone quiet
+func runPresence() {
+	ticker := time.NewTicker(presenceSyncInterval)
+	defer ticker.Stop()
+
+	queuePresenceSync()
+	for {
+		select {
+		case <-localChanges.ready:
+			for _, change := range takePresenceChanges() {
+				applyPresenceChange(change)
+			}
+
+		case event := <-presenceOut:
+			event.UserIDs = localUserIDs()
+			publish(event)
+
+		case <-ticker.C:
+			publish(broker.Event{Type: broker.EventPresenceSync, UserIDs: localUserIDs()})
+			expireRemoteNodes()
+		}
+	}
+}
+
+// expireRemoteNodes drops instances that missed several syncs, taking their
+// users offline unless they are connected somewhere else
+func expireRemoteNodes() {
+	cutoff := time.Now().Add(-presenceNodeTimeout)
+
+	cluster.Lock()
+	var gone []int64
+	for id, node := range cluster.nodes {
+		if node.seen.Before(cutoff) {
+			for userID := range node.users {
+				gone = append(gone, userID)
+			}
+			delete(cluster.nodes, id)
+			log.Printf("Instance %s stopped syncing presence", id)
+		}
+	}
+	cluster.Unlock()
+
+	for _, userID := range gone {
+		if !IsUserOnline(userID) {
+			broadcastOnlineStatus(userID, false)
+		}
+	}
+}
+
+// localUserIDs lists users with at least one device on this instance
+func localUserIDs() []int64 {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+	ids := make([]int64, 0, len(hub.clients))
+	for userID := range hub.clients {
+		ids = append(ids, userID)
+	}
+	return ids
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"scuffedsnap/database"
+)
+
+func TestPresenceChangesReachFriendsInOrder(t *testing.T) {
+	user, err := database.CreateUserWithAuth("presence_user", "presence_user@example.com", "x", "email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	friend, err := database.CreateUserWithAuth("presence_friend", "presence_friend@example.com", "x", "email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := database.CreateFriendRequest(user.ID, friend.ID, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	req, err := database.GetFriendship(user.ID, friend.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := database.AcceptFriendRequest(req.ID, friend.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := newTestServer(t)
+	watcher := dial(t, srv, friend.ID, "phone")
+	waitFor(t, "friend registration", func() bool { return isLocallyOnline(friend.ID) })
+
+	// Connect and drop straight away; the hub queues both changes
+	conn := dial(t, srv, user.ID, "phone")
+	waitFor(t, "registration", func() bool { return isLocallyOnline(user.ID) })
+	conn.Close()
+
+	var seen []bool
+	watcher.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for len(seen) < 2 {
+		_, data, err := watcher.ReadMessage()
+		if err != nil {
+			t.Fatalf("waiting for presence events: %v (got %v)", err, seen)
+		}
+		var event struct {
+			Type    string `json:"type"`
+			Payload struct {
+				UserID int64 `json:"user_id"`
+				Online bool  `json:"online"`
+			} `json:"payload"`
+		}
+		if json.Unmarshal(data, &event) != nil || event.Type != "online_status" || event.Payload.UserID != user.ID {
+			continue
+		}
+		seen = append(seen, event.Payload.Online)
+	}
+	if !seen[0] || seen[1] {
+		t.Fatalf("presence events = %v, want [true false]", seen)
+	}
+
+	stored, err := database.GetUserPresence([]int64{user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored[user.ID].LastSeen == nil {
+		t.Fatal("last seen was not recorded")
+	}
+}
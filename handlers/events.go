@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"scuffedsnap/database"
+	"scuffedsnap/models"
+)
+
+const (
+	// maxReplayEvents caps how many missed events a reconnect replays; a
+	// client further behind is told to resync over REST instead
+	maxReplayEvents = 200
+	// eventLogRetention is how long logged events stay replayable
+	eventLogRetention = 7 * 24 * time.Hour
+)
+
+// ephemeralEvents are only worth delivering live and are never logged
+var ephemeralEvents = map[string]bool{
+	"typing":        true,
+	"online_status": true,
+}
+
+// logEvent appends an event to each recipient's log and returns their
+// sequence numbers. Events carrying a message are tied to it, so deleting the
+// message removes them from the log too.
+func logEvent(recipients []int64, eventType string, payload []byte, value interface{}) map[int64]int64 {
+	var messageID int64
+	switch m := value.(type) {
+	case models.MessageWithSender:
+		messageID = m.ID
+	case *models.Message:
+		messageID = m.ID
+	case models.Message:
+		messageID = m.ID
+	case models.ReactionEvent:
+		messageID = m.MessageID
+	}
+
+	seqs, err := database.AppendUserEvents(recipients, eventType, string(payload), messageID)
+	if err != nil {
+		// Still deliver live; the event just can't be replayed
+		log.Printf("Error logging %s event: %v", eventType, err)
+		return nil
+	}
+	return seqs
+}
+
+// replayEvents sends a reconnecting client the events logged after since,
+// then releases live events held back meanwhile. If the log no longer covers
+// since, or the client missed too much, it gets resync_required instead.
+func replayEvents(c *Client, since, latest, floor int64) {
+	var events []models.UserEvent
+	complete := since >= floor && since <= latest
+	if complete {
+		var err error
+		events, err = database.GetUserEvents(c.UserID, since, maxReplayEvents+1)
+		if err != nil {
+			log.Printf("Error loading events to replay: %v", err)
+		}
+		complete = err == nil && len(events) <= maxReplayEvents
+	}
+
+	if !complete {
+		data, _ := json.Marshal(models.WebSocketMessage{
+			Type:    "resync_required",
+			Payload: map[string]int64{"seq": latest},
+		})
+		c.enqueue(data)
+		c.finishReplay(latest)
+		return
+	}
+
+	lastSeq := since
+	for _, event := range events {
+		data, _ := json.Marshal(models.WebSocketMessage{
+			Type:    event.Type,
+			Payload: json.RawMessage(event.Payload),
+			Seq:     event.Seq,
+		})
+		c.enqueue(data)
+		lastSeq = event.Seq
+	}
+	c.finishReplay(lastSeq)
+}
+
+// pruneEventLog drops logged events older than eventLogRetention
+func pruneEventLog() {
+	if _, err := database.PruneUserEvents(time.Now().Add(-eventLogRetention)); err != nil {
+		log.Printf("Error pruning event log: %v", err)
+	}
+}
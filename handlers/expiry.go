@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"log"
+	"time"
+
+	"scuffedsnap/database"
+	"scuffedsnap/models"
+)
+
+const (
+	// expirySweepInterval is how often expired messages are purged. Queries
+	// already hide expired rows, so this only bounds how late clients hear.
+	expirySweepInterval = 10 * time.Second
+	// expirySweepBatch caps how many messages one delete statement removes
+	expirySweepBatch = 500
+)
+
+// RunExpirySweeper purges expired messages, lapsed snaps, expired friend
+// requests and old event log entries on a timer
+func RunExpirySweeper() {
+	ticker := time.NewTicker(expirySweepInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		sweepExpiredMessages()
+		sweepOpenedSnaps()
+		sweepExpiredFriendRequests()
+		pruneEventLog()
+	}
+}
+
+// sweepExpiredMessages deletes expired messages in batches and tells everyone
+// in each conversation to drop them
+func sweepExpiredMessages() {
+	for {
+		expired, err := database.DeleteExpiredMessages(expirySweepBatch)
+		if err != nil {
+			log.Printf("Error deleting expired messages: %v", err)
+			return
+		}
+
+		DiscardMessageMedia(expired)
+		for i := range expired {
+			msg := &expired[i]
+			BroadcastToUsers(messageParticipants(msg), 0, models.WebSocketMessage{
+				Type: "message_expired",
+				Payload: map[string]interface{}{
+					"id":              msg.ID,
+					"sender_id":       msg.SenderID,
+					"receiver_id":     msg.ReceiverID,
+					"conversation_id": msg.ConversationID,
+				},
+			})
+		}
+
+		if len(expired) < expirySweepBatch {
+			return
+		}
+	}
+}
+
+// sweepExpiredFriendRequests deletes lapsed friend requests and tells both
+// sides to drop them from their incoming and outgoing lists
+func sweepExpiredFriendRequests() {
+	expired, err := database.DeleteExpiredFriendRequests()
+	if err != nil {
+		log.Printf("Error deleting expired friend requests: %v", err)
+		return
+	}
+	for _, request := range expired {
+		BroadcastToUsers([]int64{request.UserID, request.FriendID}, 0, models.WebSocketMessage{
+			Type: "friend_expired",
+			Payload: map[string]interface{}{
+				"request_id": request.ID,
+				"user_id":    request.UserID,
+				"friend_id":  request.FriendID,
+			},
+		})
+	}
+}
+
+// sweepOpenedSnaps destroys snaps whose viewing window has closed. OpenSnap
+// schedules this itself, but the timer doesn't survive a restart.
+func sweepOpenedSnaps() {
+	snaps, err := database.GetOpenedSnaps()
+	if err != nil {
+		log.Printf("Error listing opened snaps: %v", err)
+		return
+	}
+	for i := range snaps {
+		if !snapViewable(&snaps[i]) {
+			destroySnap(&snaps[i])
+		}
+	}
+}
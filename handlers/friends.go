@@ -1,9 +1,14 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/gorilla/mux"
 
@@ -12,6 +17,9 @@ import (
 	"scuffedsnap/models"
 )
 
+// friendRequestTTL is how long a friend request stays pending before it lapses
+const friendRequestTTL = 30 * 24 * time.Hour
+
 type addFriendRequest struct {
 	Username string `json:"username"`
 }
@@ -32,10 +40,12 @@ func GetFriends(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Add online status
+	// Add online status and last seen
+	users := make([]*models.UserResponse, len(friends))
 	for i := range friends {
-		friends[i].Online = IsUserOnline(friends[i].ID)
+		users[i] = &friends[i]
 	}
+	fillPresence(user.ID, users...)
 
 	if friends == nil {
 		friends = []models.UserResponse{}
@@ -109,7 +119,11 @@ func AddFriend(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Create friend request
-	if err := database.CreateFriendRequest(user.ID, friend.ID); err != nil {
+	if err := database.CreateFriendRequest(user.ID, friend.ID, time.Now().Add(friendRequestTTL)); err != nil {
+		if errors.Is(err, database.ErrBlocked) {
+			http.Error(w, `{"error": "Cannot add this user"}`, http.StatusForbidden)
+			return
+		}
 		http.Error(w, `{"error": "Failed to send friend request"}`, http.StatusInternalServerError)
 		return
 	}
@@ -128,8 +142,8 @@ func AddFriend(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// AcceptFriend accepts a friend request
-func AcceptFriend(w http.ResponseWriter, r *http.Request) {
+// GetOutgoingFriendRequests returns the pending requests the current user has sent
+func GetOutgoingFriendRequests(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	user := middleware.GetUserFromContext(r)
@@ -138,24 +152,154 @@ func AcceptFriend(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	requests, err := database.GetOutgoingFriendRequests(user.ID)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to get friend requests"}`, http.StatusInternalServerError)
+		return
+	}
+
+	if requests == nil {
+		requests = []models.OutgoingFriendRequest{}
+	}
+
+	json.NewEncoder(w).Encode(requests)
+}
+
+// friendRequest loads the {id} friend request, writing an error response and
+// returning nil if it is missing, expired or not addressed to or from the user
+func friendRequest(w http.ResponseWriter, r *http.Request, user *models.User) *models.Friend {
 	vars := mux.Vars(r)
 	requestID, err := strconv.ParseInt(vars["id"], 10, 64)
 	if err != nil {
 		http.Error(w, `{"error": "Invalid request ID"}`, http.StatusBadRequest)
+		return nil
+	}
+
+	request, err := database.GetFriendRequest(requestID)
+	if err != nil || (request.UserID != user.ID && request.FriendID != user.ID) {
+		http.Error(w, `{"error": "Friend request not found"}`, http.StatusNotFound)
+		return nil
+	}
+	return request
+}
+
+// AcceptFriend accepts a friend request
+func AcceptFriend(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user := middleware.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	request := friendRequest(w, r, user)
+	if request == nil {
 		return
 	}
 
-	if err := database.AcceptFriendRequest(requestID, user.ID); err != nil {
+	if err := database.AcceptFriendRequest(request.ID, user.ID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, `{"error": "Friend request not found"}`, http.StatusNotFound)
+			return
+		}
 		http.Error(w, `{"error": "Failed to accept friend request"}`, http.StatusInternalServerError)
 		return
 	}
 
+	// Let the requester know they have a new friend, and whether they're around
+	friend := user.ToResponse()
+	fillPresence(request.UserID, &friend)
+	BroadcastMessage(request.UserID, models.WebSocketMessage{
+		Type: "friend_accepted",
+		Payload: map[string]interface{}{
+			"request_id": request.ID,
+			"friend":     friend,
+		},
+	})
+
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"success": true,
 		"message": "Friend request accepted",
 	})
 }
 
+// DeclineFriend declines a friend request sent to the current user
+func DeclineFriend(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user := middleware.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	request := friendRequest(w, r, user)
+	if request == nil {
+		return
+	}
+
+	if err := database.DeclineFriendRequest(request.ID, user.ID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, `{"error": "Friend request not found"}`, http.StatusNotFound)
+			return
+		}
+		http.Error(w, `{"error": "Failed to decline friend request"}`, http.StatusInternalServerError)
+		return
+	}
+
+	BroadcastMessage(request.UserID, models.WebSocketMessage{
+		Type: "friend_declined",
+		Payload: map[string]interface{}{
+			"request_id": request.ID,
+			"user_id":    user.ID,
+		},
+	})
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Friend request declined",
+	})
+}
+
+// CancelFriend withdraws a friend request the current user sent
+func CancelFriend(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user := middleware.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	request := friendRequest(w, r, user)
+	if request == nil {
+		return
+	}
+
+	if err := database.CancelFriendRequest(request.ID, user.ID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, `{"error": "Friend request not found"}`, http.StatusNotFound)
+			return
+		}
+		http.Error(w, `{"error": "Failed to cancel friend request"}`, http.StatusInternalServerError)
+		return
+	}
+
+	BroadcastMessage(request.FriendID, models.WebSocketMessage{
+		Type: "friend_cancelled",
+		Payload: map[string]interface{}{
+			"request_id": request.ID,
+			"user_id":    user.ID,
+		},
+	})
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Friend request cancelled",
+	})
+}
+
 // RemoveFriend removes a friend
 func RemoveFriend(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -184,7 +328,98 @@ func RemoveFriend(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// SearchUsers searches for users by username
+// GetBlockedUsers returns the users the current user has blocked
+func GetBlockedUsers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user := middleware.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	blocked, err := database.GetBlockedUsers(user.ID)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to get blocked users"}`, http.StatusInternalServerError)
+		return
+	}
+
+	if blocked == nil {
+		blocked = []models.UserResponse{}
+	}
+
+	json.NewEncoder(w).Encode(blocked)
+}
+
+// BlockUser blocks a user, ending any friendship with them
+func BlockUser(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user := middleware.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	blockedID, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil || blockedID == user.ID {
+		http.Error(w, `{"error": "Invalid user ID"}`, http.StatusBadRequest)
+		return
+	}
+
+	if _, err := database.GetUserByID(blockedID); err != nil {
+		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+		return
+	}
+
+	if err := database.BlockUser(user.ID, blockedID); err != nil {
+		http.Error(w, `{"error": "Failed to block user"}`, http.StatusInternalServerError)
+		return
+	}
+
+	// The blocked user stops seeing our presence, so show us as offline
+	BroadcastMessage(blockedID, presenceEvent(user.ID, models.PresenceOffline, nil))
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "User blocked",
+	})
+}
+
+// UnblockUser lifts a block placed by the current user
+func UnblockUser(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user := middleware.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	blockedID, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, `{"error": "Invalid user ID"}`, http.StatusBadRequest)
+		return
+	}
+
+	if err := database.UnblockUser(user.ID, blockedID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, `{"error": "User is not blocked"}`, http.StatusNotFound)
+			return
+		}
+		http.Error(w, `{"error": "Failed to unblock user"}`, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "User unblocked",
+	})
+}
+
+// SearchUsers finds users by username, best matches first
 func SearchUsers(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
@@ -194,21 +429,57 @@ func SearchUsers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	query := r.URL.Query().Get("q")
-	if query == "" {
-		json.NewEncoder(w).Encode([]models.UserResponse{})
+	query := r.URL.Query()
+	q := strings.TrimSpace(query.Get("q"))
+	if q == "" {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"users":    []models.UserSearchResult{},
+			"has_more": false,
+		})
+		return
+	}
+	if utf8.RuneCountInString(q) > 64 {
+		http.Error(w, `{"error": "Search query is too long"}`, http.StatusBadRequest)
 		return
 	}
 
-	users, err := database.SearchUsers(query, user.ID)
+	// A cursor from a previous page continues after its last result
+	limit := 20
+	if l := query.Get("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 50 {
+			limit = parsed
+		}
+	}
+	var after *models.UserSearchCursor
+	if c := query.Get("cursor"); c != "" {
+		var err error
+		if after, err = models.ParseUserSearchCursor(c); err != nil {
+			http.Error(w, `{"error": "Invalid cursor"}`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	users, hasMore, err := database.SearchUsers(q, user.ID, limit, after)
 	if err != nil {
 		http.Error(w, `{"error": "Search failed"}`, http.StatusInternalServerError)
 		return
 	}
 
-	if users == nil {
-		users = []models.UserResponse{}
+	responses := make([]*models.UserResponse, len(users))
+	for i := range users {
+		responses[i] = &users[i].UserResponse
+	}
+	fillPresence(user.ID, responses...)
+
+	resp := map[string]interface{}{
+		"users":    users,
+		"has_more": hasMore,
+	}
+	if len(users) == 0 {
+		resp["users"] = []models.UserSearchResult{}
+	} else {
+		resp["next_cursor"] = users[len(users)-1].Position.String()
 	}
 
-	json.NewEncoder(w).Encode(users)
+	json.NewEncoder(w).Encode(resp)
 }
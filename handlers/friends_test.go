@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"scuffedsnap/database"
+	"scuffedsnap/middleware"
+	"scuffedsnap/models"
+)
+
+func TestFriendsListFallsBackToIdenticons(t *testing.T) {
+	user, err := database.CreateUserWithAuth("avatar_user", "avatar_user@example.com", "x", "email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	friend, err := database.CreateUserWithAuth("avatar_friend", "avatar_friend@example.com", "x", "email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploader, err := database.CreateUserWithAuth("avatar_uploader", "avatar_uploader@example.com", "x", "email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := database.UpdateUserAvatar(uploader.ID, "/api/avatars/uploaded"); err != nil {
+		t.Fatal(err)
+	}
+	for _, other := range []*models.User{friend, uploader} {
+		if err := database.CreateFriendRequest(user.ID, other.ID, time.Now().Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		req, err := database.GetFriendship(user.ID, other.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := database.AcceptFriendRequest(req.ID, other.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/friends", nil)
+	r = r.WithContext(context.WithValue(r.Context(), middleware.UserContextKey, user))
+	w := httptest.NewRecorder()
+	GetFriends(w, r)
+
+	var friends []models.UserResponse
+	if err := json.NewDecoder(w.Body).Decode(&friends); err != nil {
+		t.Fatal(err)
+	}
+	want := map[int64]string{
+		friend.ID:   models.IdenticonURL(friend.ID),
+		uploader.ID: "/api/avatars/uploaded",
+	}
+	if len(friends) != len(want) {
+		t.Fatalf("got %d friends, want %d", len(friends), len(want))
+	}
+	for _, f := range friends {
+		if f.Avatar != want[f.ID] {
+			t.Fatalf("%s has avatar %q, want %q", f.Username, f.Avatar, want[f.ID])
+		}
+	}
+}
+
+func TestExpiredFriendRequestsNotifyBothSides(t *testing.T) {
+	from, err := database.CreateUserWithAuth("lapsed_from", "lapsed_from@example.com", "x", "email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := database.CreateUserWithAuth("lapsed_to", "lapsed_to@example.com", "x", "email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := database.CreateFriendRequest(from.ID, to.ID, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	sweepExpiredFriendRequests()
+
+	for _, user := range []*models.User{from, to} {
+		events, err := database.GetUserEvents(user.ID, 0, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != 1 || events[0].Type != "friend_expired" {
+			t.Fatalf("%s's events = %+v, want one friend_expired", user.Username, events)
+		}
+	}
+	if _, err := database.GetFriendship(from.ID, to.ID); err == nil {
+		t.Fatal("expired request was not deleted")
+	}
+}
@@ -0,0 +1,566 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"scuffedsnap/database"
+	"scuffedsnap/middleware"
+	"scuffedsnap/models"
+)
+
+const (
+	maxGroupNameLength = 50
+	maxGroupMembers    = 50
+)
+
+type createGroupRequest struct {
+	Name      string  `json:"name"`
+	Avatar    string  `json:"avatar"`
+	MemberIDs []int64 `json:"member_ids"`
+}
+
+type updateGroupRequest struct {
+	Name   *string `json:"name"`
+	Avatar *string `json:"avatar"`
+}
+
+type inviteMemberRequest struct {
+	UserID   int64  `json:"user_id"`
+	Username string `json:"username"`
+}
+
+type setRoleRequest struct {
+	Role models.GroupRole `json:"role"`
+}
+
+// groupMembership resolves the {id} route variable and the caller's membership,
+// writing an error response and returning ok=false if either is missing
+func groupMembership(w http.ResponseWriter, r *http.Request, user *models.User) (int64, *models.GroupMember, bool) {
+	groupID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, `{"error": "Invalid group ID"}`, http.StatusBadRequest)
+		return 0, nil, false
+	}
+
+	member, err := database.GetGroupMember(groupID, user.ID)
+	if err != nil {
+		http.Error(w, `{"error": "Group not found"}`, http.StatusNotFound)
+		return 0, nil, false
+	}
+	return groupID, member, true
+}
+
+// notifyGroup sends an event to every member of a group except exceptUserID
+func notifyGroup(groupID, exceptUserID int64, msg models.WebSocketMessage) {
+	memberIDs, err := database.GetGroupMemberIDs(groupID)
+	if err != nil {
+		return
+	}
+	BroadcastToUsers(memberIDs, exceptUserID, msg)
+}
+
+// CreateGroup creates a group conversation owned by the current user
+func CreateGroup(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user := middleware.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var req createGroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > maxGroupNameLength {
+		http.Error(w, `{"error": "Group name must be 1-50 characters"}`, http.StatusBadRequest)
+		return
+	}
+
+	if len(req.MemberIDs)+1 > maxGroupMembers {
+		http.Error(w, `{"error": "Too many group members"}`, http.StatusBadRequest)
+		return
+	}
+
+	for _, memberID := range req.MemberIDs {
+		if _, err := database.GetUserByID(memberID); err != nil {
+			http.Error(w, `{"error": "Member not found"}`, http.StatusNotFound)
+			return
+		}
+		if blocked, _ := database.IsBlocked(user.ID, memberID); blocked {
+			http.Error(w, `{"error": "Cannot add this user"}`, http.StatusForbidden)
+			return
+		}
+	}
+
+	group, err := database.CreateGroup(user.ID, req.Name, req.Avatar, req.MemberIDs)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to create group"}`, http.StatusInternalServerError)
+		return
+	}
+
+	// Tell the invited members about their new group
+	notifyGroup(group.ID, user.ID, models.WebSocketMessage{
+		Type: "group_invite",
+		Payload: map[string]interface{}{
+			"group": group,
+			"from":  user.ToResponse(),
+		},
+	})
+
+	json.NewEncoder(w).Encode(group)
+}
+
+// GetGroup returns a group and its members
+func GetGroup(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user := middleware.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	groupID, _, ok := groupMembership(w, r, user)
+	if !ok {
+		return
+	}
+
+	group, err := database.GetGroup(groupID)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to get group"}`, http.StatusInternalServerError)
+		return
+	}
+
+	// Only members who are friends show their presence
+	users := make([]*models.UserResponse, len(group.Members))
+	for i := range group.Members {
+		users[i] = &group.Members[i].User
+	}
+	fillPresence(user.ID, users...)
+
+	json.NewEncoder(w).Encode(group)
+}
+
+// UpdateGroup renames a group or changes its avatar (owner or admin only)
+func UpdateGroup(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user := middleware.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	groupID, member, ok := groupMembership(w, r, user)
+	if !ok {
+		return
+	}
+
+	if !member.Role.CanManage() {
+		http.Error(w, `{"error": "Only group owners and admins can edit the group"}`, http.StatusForbidden)
+		return
+	}
+
+	var req updateGroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	group, err := database.GetGroup(groupID)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to get group"}`, http.StatusInternalServerError)
+		return
+	}
+
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" || len(name) > maxGroupNameLength {
+			http.Error(w, `{"error": "Group name must be 1-50 characters"}`, http.StatusBadRequest)
+			return
+		}
+		group.Name = name
+	}
+	if req.Avatar != nil {
+		group.Avatar = *req.Avatar
+	}
+
+	if err := database.UpdateGroup(groupID, group.Name, group.Avatar); err != nil {
+		http.Error(w, `{"error": "Failed to update group"}`, http.StatusInternalServerError)
+		return
+	}
+
+	notifyGroup(groupID, user.ID, models.WebSocketMessage{
+		Type:    "group_updated",
+		Payload: group,
+	})
+
+	json.NewEncoder(w).Encode(group)
+}
+
+// InviteGroupMember adds a user to a group (owner or admin only)
+func InviteGroupMember(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user := middleware.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	groupID, member, ok := groupMembership(w, r, user)
+	if !ok {
+		return
+	}
+
+	if !member.Role.CanManage() {
+		http.Error(w, `{"error": "Only group owners and admins can invite members"}`, http.StatusForbidden)
+		return
+	}
+
+	var req inviteMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	var invitee *models.User
+	var err error
+	if req.UserID != 0 {
+		invitee, err = database.GetUserByID(req.UserID)
+	} else {
+		invitee, err = database.GetUserByUsername(strings.TrimSpace(req.Username))
+	}
+	if err != nil {
+		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+		return
+	}
+
+	if _, err := database.GetGroupMember(groupID, invitee.ID); err == nil {
+		http.Error(w, `{"error": "User is already a member"}`, http.StatusConflict)
+		return
+	}
+
+	if blocked, _ := database.IsBlocked(user.ID, invitee.ID); blocked {
+		http.Error(w, `{"error": "Cannot add this user"}`, http.StatusForbidden)
+		return
+	}
+
+	memberIDs, err := database.GetGroupMemberIDs(groupID)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to get group"}`, http.StatusInternalServerError)
+		return
+	}
+	if len(memberIDs) >= maxGroupMembers {
+		http.Error(w, `{"error": "Group is full"}`, http.StatusConflict)
+		return
+	}
+
+	if err := database.AddGroupMember(groupID, invitee.ID, models.GroupRoleMember); err != nil {
+		http.Error(w, `{"error": "Failed to add member"}`, http.StatusInternalServerError)
+		return
+	}
+
+	group, err := database.GetGroup(groupID)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to get group"}`, http.StatusInternalServerError)
+		return
+	}
+
+	BroadcastMessage(invitee.ID, models.WebSocketMessage{
+		Type: "group_invite",
+		Payload: map[string]interface{}{
+			"group": group,
+			"from":  user.ToResponse(),
+		},
+	})
+	BroadcastToUsers(memberIDs, user.ID, models.WebSocketMessage{
+		Type: "group_member_added",
+		Payload: map[string]interface{}{
+			"group_id": groupID,
+			"user":     invitee.ToResponse(),
+		},
+	})
+
+	json.NewEncoder(w).Encode(group)
+}
+
+// KickGroupMember removes another member from a group. Owners can remove
+// anyone; admins can only remove regular members.
+func KickGroupMember(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user := middleware.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	groupID, member, ok := groupMembership(w, r, user)
+	if !ok {
+		return
+	}
+
+	targetID, err := strconv.ParseInt(mux.Vars(r)["userId"], 10, 64)
+	if err != nil {
+		http.Error(w, `{"error": "Invalid user ID"}`, http.StatusBadRequest)
+		return
+	}
+
+	if targetID == user.ID {
+		http.Error(w, `{"error": "Use leave to exit a group"}`, http.StatusBadRequest)
+		return
+	}
+
+	target, err := database.GetGroupMember(groupID, targetID)
+	if err != nil {
+		http.Error(w, `{"error": "Member not found"}`, http.StatusNotFound)
+		return
+	}
+
+	canKick := member.Role == models.GroupRoleOwner ||
+		(member.Role == models.GroupRoleAdmin && target.Role == models.GroupRoleMember)
+	if !canKick {
+		http.Error(w, `{"error": "You cannot remove this member"}`, http.StatusForbidden)
+		return
+	}
+
+	if err := database.RemoveGroupMember(groupID, targetID); err != nil {
+		http.Error(w, `{"error": "Failed to remove member"}`, http.StatusInternalServerError)
+		return
+	}
+
+	event := models.WebSocketMessage{
+		Type: "group_member_removed",
+		Payload: map[string]interface{}{
+			"group_id": groupID,
+			"user_id":  targetID,
+			"by":       user.ID,
+		},
+	}
+	BroadcastMessage(targetID, event)
+	notifyGroup(groupID, user.ID, event)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Member removed",
+	})
+}
+
+// SetGroupMemberRole promotes or demotes a member (owner only). Setting
+// another member's role to owner transfers ownership and demotes the caller
+// to admin.
+func SetGroupMemberRole(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user := middleware.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	groupID, member, ok := groupMembership(w, r, user)
+	if !ok {
+		return
+	}
+
+	if member.Role != models.GroupRoleOwner {
+		http.Error(w, `{"error": "Only the group owner can change roles"}`, http.StatusForbidden)
+		return
+	}
+
+	targetID, err := strconv.ParseInt(mux.Vars(r)["userId"], 10, 64)
+	if err != nil || targetID == user.ID {
+		http.Error(w, `{"error": "Invalid user ID"}`, http.StatusBadRequest)
+		return
+	}
+
+	var req setRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	switch req.Role {
+	case models.GroupRoleOwner, models.GroupRoleAdmin, models.GroupRoleMember:
+	default:
+		http.Error(w, `{"error": "Invalid role"}`, http.StatusBadRequest)
+		return
+	}
+
+	if req.Role == models.GroupRoleOwner {
+		err = database.TransferGroupOwnership(groupID, user.ID, targetID)
+	} else {
+		err = database.SetGroupMemberRole(groupID, targetID, req.Role)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, `{"error": "Member not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error": "Failed to update role"}`, http.StatusInternalServerError)
+		return
+	}
+
+	notifyGroup(groupID, 0, models.WebSocketMessage{
+		Type: "group_role_changed",
+		Payload: map[string]interface{}{
+			"group_id": groupID,
+			"user_id":  targetID,
+			"role":     req.Role,
+		},
+	})
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Role updated",
+	})
+}
+
+// LeaveGroup removes the current user from a group
+func LeaveGroup(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user := middleware.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	groupID, _, ok := groupMembership(w, r, user)
+	if !ok {
+		return
+	}
+
+	newOwnerID, deleted, removed, err := database.LeaveGroup(groupID, user.ID)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to leave group"}`, http.StatusInternalServerError)
+		return
+	}
+	DiscardMessageMedia(removed)
+
+	if !deleted {
+		payload := map[string]interface{}{
+			"group_id": groupID,
+			"user_id":  user.ID,
+		}
+		if newOwnerID != 0 {
+			payload["new_owner_id"] = newOwnerID
+		}
+		notifyGroup(groupID, user.ID, models.WebSocketMessage{
+			Type:    "group_member_left",
+			Payload: payload,
+		})
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Left group",
+	})
+}
+
+// GetGroupMessages returns a group's message history and marks it read
+func GetGroupMessages(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user := middleware.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	groupID, _, ok := groupMembership(w, r, user)
+	if !ok {
+		return
+	}
+
+	// Get pagination params
+	limit := 50
+	offset := 0
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 100 {
+			limit = parsed
+		}
+	}
+	if o := r.URL.Query().Get("offset"); o != "" {
+		if parsed, err := strconv.Atoi(o); err == nil && parsed >= 0 {
+			offset = parsed
+		}
+	}
+
+	messages, err := database.GetGroupMessages(groupID, limit, offset)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to get messages"}`, http.StatusInternalServerError)
+		return
+	}
+
+	// Mark messages as read
+	database.MarkGroupAsRead(groupID, user.ID)
+
+	if messages == nil {
+		messages = []models.MessageWithSender{}
+	}
+
+	json.NewEncoder(w).Encode(messages)
+}
+
+// MarkGroupAsRead marks every message in a group as read for the current user
+func MarkGroupAsRead(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user := middleware.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	groupID, _, ok := groupMembership(w, r, user)
+	if !ok {
+		return
+	}
+
+	if err := database.MarkGroupAsRead(groupID, user.ID); err != nil {
+		http.Error(w, `{"error": "Failed to mark as read"}`, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// sendGroupMessage posts a message to a group and fans it out to every member.
+// sendMessage has already checked that the sender belongs to the group.
+func sendGroupMessage(user *models.User, deviceID string, req sendMessageRequest) (*models.Message, bool, *sendError) {
+	message, err := database.CreateGroupMessage(user.ID, req.ConversationID, req.Content, req.Type, req.ClientID, req.ReplyToID)
+	if err != nil {
+		return duplicateSend(user, req.ClientID)
+	}
+	if sendErr := attachImageMedia(message); sendErr != nil {
+		return nil, false, sendErr
+	}
+	fillReplyPreview(message)
+
+	// Every member hears of it, the sender's other devices included
+	memberIDs, _ := database.GetGroupMemberIDs(req.ConversationID)
+	broadcastFromDevice(memberIDs, user.ID, deviceID, models.WebSocketMessage{
+		Type: "message",
+		Payload: models.MessageWithSender{
+			Message:        *message,
+			SenderUsername: user.Username,
+			SenderAvatar:   models.AvatarURL(user.ID, user.Avatar),
+		},
+	})
+
+	return message, false, nil
+}
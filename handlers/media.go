@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"scuffedsnap/database"
+	"scuffedsnap/media"
+	"scuffedsnap/middleware"
+	"scuffedsnap/models"
+)
+
+// mediaStore holds uploaded blobs, set by SetMediaStore
+var mediaStore media.BlobStore
+
+// SetMediaStore sets the blob store used for uploads
+func SetMediaStore(s media.BlobStore) {
+	mediaStore = s
+}
+
+// mediaURL is where a media ID is served from
+func mediaURL(id string) string {
+	return "/api/media/" + id
+}
+
+// UploadMedia stores an image from a multipart "file" field and returns its
+// ID for use as the content of an image message
+func UploadMedia(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user := middleware.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	if mediaStore == nil {
+		http.Error(w, `{"error": "Media storage is not configured"}`, http.StatusServiceUnavailable)
+		return
+	}
+
+	data, ok := readUpload(w, r)
+	if !ok {
+		return
+	}
+
+	mimeType, ok := media.DetectImageType(data)
+	if !ok {
+		http.Error(w, `{"error": "Unsupported file type"}`, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	upload := &models.Media{
+		ID:       media.NewID(),
+		OwnerID:  user.ID,
+		MimeType: mimeType,
+		Size:     int64(len(data)),
+	}
+
+	if err := mediaStore.Put(upload.ID, bytes.NewReader(data)); err != nil {
+		http.Error(w, `{"error": "Failed to store file"}`, http.StatusInternalServerError)
+		return
+	}
+
+	if err := database.CreateMedia(upload); err != nil {
+		mediaStore.Delete(upload.ID)
+		http.Error(w, `{"error": "Failed to store file"}`, http.StatusInternalServerError)
+		return
+	}
+
+	upload.URL = mediaURL(upload.ID)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(upload)
+}
+
+// readUpload reads the multipart "file" field within the upload size limit,
+// writing an error response and returning ok=false on failure
+func readUpload(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	maxBytes := media.MaxUploadBytes()
+	// Leave headroom for the multipart framing around the file itself
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes+64<<10)
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, `{"error": "File is too large"}`, http.StatusRequestEntityTooLarge)
+			return nil, false
+		}
+		http.Error(w, `{"error": "A file field is required"}`, http.StatusBadRequest)
+		return nil, false
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxBytes+1))
+	if err != nil {
+		http.Error(w, `{"error": "Failed to read upload"}`, http.StatusBadRequest)
+		return nil, false
+	}
+	if int64(len(data)) > maxBytes {
+		http.Error(w, `{"error": "File is too large"}`, http.StatusRequestEntityTooLarge)
+		return nil, false
+	}
+	if len(data) == 0 {
+		http.Error(w, `{"error": "File is empty"}`, http.StatusBadRequest)
+		return nil, false
+	}
+	return data, true
+}
+
+// GetMedia serves an uploaded file. The uploader can always fetch it; once
+// attached to a message, so can everyone in that message's conversation.
+func GetMedia(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user := middleware.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	upload, err := database.GetMedia(mux.Vars(r)["id"])
+	if err != nil || !canViewMedia(upload, user.ID) {
+		// Don't reveal whether media the user can't see exists
+		http.Error(w, `{"error": "Media not found"}`, http.StatusNotFound)
+		return
+	}
+
+	blob, err := mediaStore.Get(upload.ID)
+	if err != nil {
+		http.Error(w, `{"error": "Media not found"}`, http.StatusNotFound)
+		return
+	}
+	defer blob.Close()
+
+	w.Header().Set("Content-Type", upload.MimeType)
+	w.Header().Set("Content-Length", strconv.FormatInt(upload.Size, 10))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	io.Copy(w, blob)
+}
+
+// canViewMedia reports whether userID may fetch an upload
+func canViewMedia(upload *models.Media, userID int64) bool {
+	if upload.OwnerID == userID {
+		return true
+	}
+	if upload.MessageID == 0 {
+		return false
+	}
+
+	message, err := database.GetMessageByID(upload.MessageID)
+	if err != nil || (message.ExpiresAt != nil && message.ExpiresAt.Before(time.Now())) {
+		return false
+	}
+	// Snaps are only released through OpenSnap
+	if message.Type == "snap" {
+		return false
+	}
+	for _, id := range messageParticipants(message) {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// imageMedia checks that an image message or snap's content is one of the
+// sender's own unattached uploads
+func imageMedia(user *models.User, mediaID string) *sendError {
+	upload, err := database.GetMedia(mediaID)
+	if err != nil || upload.OwnerID != user.ID {
+		return newSendError(http.StatusBadRequest, sendErrInvalid, "Media not found")
+	}
+	if upload.MessageID != 0 {
+		return newSendError(http.StatusConflict, sendErrConflict, "Media is already attached to a message")
+	}
+	return nil
+}
+
+// attachImageMedia links an image message or snap's upload to it. If another message
+// claimed the upload first, the new message is removed again.
+func attachImageMedia(message *models.Message) *sendError {
+	if message.Type != "image" && message.Type != "snap" {
+		return nil
+	}
+	if err := database.AttachMedia(message.Content, message.ID); err != nil {
+		database.DeleteMessage(message.ID)
+		return newSendError(http.StatusConflict, sendErrConflict, "Media is already attached to a message")
+	}
+	return nil
+}
+
+// DiscardMessageMedia deletes the stored files of removed image messages and
+// snaps. Their media rows are removed along with the messages.
+func DiscardMessageMedia(messages []models.Message) {
+	if mediaStore == nil {
+		return
+	}
+	for _, msg := range messages {
+		if (msg.Type == "image" || msg.Type == "snap") && msg.Content != "" {
+			mediaStore.Delete(msg.Content)
+		}
+	}
+}
@@ -2,8 +2,12 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
+	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -14,13 +18,29 @@ import (
 )
 
 type sendMessageRequest struct {
-	ReceiverID int64  `json:"receiver_id"`
-	Content    string `json:"content"`
-	Type       string `json:"type"`
-	Disappear  bool   `json:"disappear"` // If true, message expires after being read
+	ReceiverID     int64  `json:"receiver_id"`
+	ConversationID int64  `json:"conversation_id"` // Set instead of receiver_id for group messages
+	Content        string `json:"content"`
+	Type           string `json:"type"`
+	Disappear      bool   `json:"disappear"`         // If true, message expires after being read
+	DisappearAfter int    `json:"disappear_seconds"` // Countdown once read, defaults to defaultDisappearSeconds
+	ViewSeconds    int    `json:"view_seconds"`      // Optional viewing duration for snaps
+	ClientID       string `json:"client_id"`         // Optional sender-generated ID; retries with it are stored once
+	ReplyToID      int64  `json:"reply_to_id"`       // Optional message in the same conversation to quote
 }
 
-// GetConversations returns all conversations for the current user
+// Disappearing message countdown bounds, in seconds
+const (
+	defaultDisappearSeconds = 60
+	maxDisappearSeconds     = 7 * 24 * 60 * 60
+)
+
+type editMessageRequest struct {
+	Content string `json:"content"`
+}
+
+// GetConversations returns a page of the current user's conversations, most
+// recently active first
 func GetConversations(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
@@ -30,22 +50,53 @@ func GetConversations(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	conversations, err := database.GetConversations(user.ID)
+	// A cursor from a previous page continues with older conversations
+	page := models.Page{Limit: 50}
+	query := r.URL.Query()
+	if l := query.Get("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 100 {
+			page.Limit = parsed
+		}
+	}
+	if b := query.Get("before"); b != "" {
+		var err error
+		if page.Before, err = models.ParseCursor(b); err != nil {
+			http.Error(w, `{"error": "Invalid before cursor"}`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	conversations, hasMore, err := database.GetConversations(user.ID, page)
 	if err != nil {
 		http.Error(w, `{"error": "Failed to get conversations"}`, http.StatusInternalServerError)
 		return
 	}
 
-	// Add online status
+	// Add online status for friends
+	var users []*models.UserResponse
+	var lastMessages []*models.Message
 	for i := range conversations {
-		conversations[i].User.Online = IsUserOnline(conversations[i].User.ID)
+		if conversations[i].User != nil {
+			users = append(users, conversations[i].User)
+		}
+		if conversations[i].LastMessage != nil {
+			lastMessages = append(lastMessages, conversations[i].LastMessage)
+		}
 	}
+	fillPresence(user.ID, users...)
+	hideReadReceipts(user.ID, lastMessages...)
 
-	if conversations == nil {
-		conversations = []models.Conversation{}
+	resp := map[string]interface{}{
+		"conversations": conversations,
+		"has_more":      hasMore,
+	}
+	if len(conversations) == 0 {
+		resp["conversations"] = []models.Conversation{}
+	} else {
+		resp["next_cursor"] = conversations[len(conversations)-1].Cursor().String()
 	}
 
-	json.NewEncoder(w).Encode(conversations)
+	json.NewEncoder(w).Encode(resp)
 }
 
 // GetMessages returns messages between current user and another user
@@ -65,34 +116,86 @@ func GetMessages(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get pagination params
-	limit := 50
-	offset := 0
-	if l := r.URL.Query().Get("limit"); l != "" {
+	// Get pagination params: a cursor from a previous page selects older
+	// (before) or newer (after) messages
+	page := models.Page{Limit: 50}
+	query := r.URL.Query()
+	if l := query.Get("limit"); l != "" {
 		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 100 {
-			limit = parsed
+			page.Limit = parsed
+		}
+	}
+	if b := query.Get("before"); b != "" {
+		if page.Before, err = models.ParseCursor(b); err != nil {
+			http.Error(w, `{"error": "Invalid before cursor"}`, http.StatusBadRequest)
+			return
 		}
 	}
-	if o := r.URL.Query().Get("offset"); o != "" {
-		if parsed, err := strconv.Atoi(o); err == nil && parsed >= 0 {
-			offset = parsed
+	if a := query.Get("after"); a != "" {
+		if page.After, err = models.ParseCursor(a); err != nil {
+			http.Error(w, `{"error": "Invalid after cursor"}`, http.StatusBadRequest)
+			return
 		}
 	}
+	if page.Before != nil && page.After != nil {
+		http.Error(w, `{"error": "Use either before or after, not both"}`, http.StatusBadRequest)
+		return
+	}
 
-	messages, err := database.GetMessagesBetweenUsers(user.ID, otherUserID, limit, offset)
+	messages, hasMore, err := database.GetMessagesBetweenUsers(user.ID, otherUserID, page)
 	if err != nil {
 		http.Error(w, `{"error": "Failed to get messages"}`, http.StatusInternalServerError)
 		return
 	}
 
-	// Mark messages as read
-	database.MarkMessagesAsRead(otherUserID, user.ID)
+	// Everything fetched from the other user is now delivered, and read up to
+	// the newest of it
+	var undelivered []int64
+	var upToID int64
+	shown := make([]*models.Message, len(messages))
+	for i := range messages {
+		msg := &messages[i].Message
+		shown[i] = msg
+		if msg.SenderID != otherUserID || msg.ReceiverID != user.ID {
+			continue
+		}
+		if msg.DeliveredAt == nil {
+			undelivered = append(undelivered, msg.ID)
+		}
+		if msg.ID > upToID {
+			upToID = msg.ID
+		}
+	}
+	if receipts, err := database.MarkMessagesDelivered(user.ID, undelivered); err == nil {
+		sendDeliveredReceipts(receipts)
+	} else {
+		log.Printf("Error marking messages delivered: %v", err)
+	}
+	if upToID != 0 {
+		if receipt, err := database.MarkMessagesAsRead(otherUserID, user.ID, upToID); err == nil {
+			sendReadReceipt(user, receipt)
+		} else {
+			log.Printf("Error marking messages read: %v", err)
+		}
+	}
+	hideReadReceipts(user.ID, shown...)
 
-	if messages == nil {
-		messages = []models.MessageWithSender{}
+	resp := map[string]interface{}{
+		"messages": messages,
+		"has_more": hasMore,
+	}
+	if len(messages) == 0 {
+		resp["messages"] = []models.MessageWithSender{}
+	} else {
+		// The next page continues from the far end of this one
+		edge := messages[0].Message
+		if page.After != nil {
+			edge = messages[len(messages)-1].Message
+		}
+		resp["next_cursor"] = models.Cursor{Time: edge.CreatedAt, ID: edge.ID}.String()
 	}
 
-	json.NewEncoder(w).Encode(messages)
+	json.NewEncoder(w).Encode(resp)
 }
 
 // SendMessage creates a new message
@@ -111,49 +214,201 @@ func SendMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Content == "" {
-		http.Error(w, `{"error": "Message content is required"}`, http.StatusBadRequest)
+	// A retried send with the same client_id returns the original message
+	message, _, sendErr := sendMessage(user, "", req)
+	if sendErr != nil {
+		http.Error(w, `{"error": "`+sendErr.Message+`"}`, sendErr.Status)
 		return
 	}
 
+	json.NewEncoder(w).Encode(message)
+}
+
+// Send error codes reported in WebSocket acks
+const (
+	sendErrInvalid   = "invalid_request"
+	sendErrNotFound  = "not_found"
+	sendErrForbidden = "forbidden"
+	sendErrConflict  = "conflict"
+	sendErrInternal  = "internal"
+)
+
+// maxClientIDLength bounds client-generated message IDs
+const maxClientIDLength = 64
+
+// sendError is a rejected send: an HTTP status for SendMessage and a typed
+// code for WebSocket acks
+type sendError struct {
+	Status  int    `json:"-"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func newSendError(status int, code, message string) *sendError {
+	return &sendError{Status: status, Code: code, Message: message}
+}
+
+// sendMessage validates and stores a message from user and delivers it to its
+// recipients and to the user's other devices; deviceID is the sending
+// device, or empty for a REST send. It reports duplicate when req.ClientID
+// matches a message the user already sent, returning that message without
+// delivering it again.
+func sendMessage(user *models.User, deviceID string, req sendMessageRequest) (message *models.Message, duplicate bool, sendErr *sendError) {
+	if len(req.ClientID) > maxClientIDLength {
+		return nil, false, newSendError(http.StatusBadRequest, sendErrInvalid, "client_id is too long")
+	}
+	if req.ClientID != "" {
+		if existing, err := database.GetMessageByClientID(user.ID, req.ClientID); err == nil {
+			fillReplyPreview(existing)
+			return existing, true, nil
+		}
+	}
+
+	if req.Content == "" {
+		return nil, false, newSendError(http.StatusBadRequest, sendErrInvalid, "Message content is required")
+	}
+
 	if req.Type == "" {
 		req.Type = "text"
 	}
 
+	if req.Type == "snap" {
+		if req.ConversationID != 0 {
+			return nil, false, newSendError(http.StatusBadRequest, sendErrInvalid, "Snaps can only be sent in direct messages")
+		}
+		if req.ViewSeconds < 0 || req.ViewSeconds > maxSnapViewSeconds {
+			return nil, false, newSendError(http.StatusBadRequest, sendErrInvalid, "view_seconds must be between 0 and 60")
+		}
+	} else if req.ViewSeconds != 0 {
+		return nil, false, newSendError(http.StatusBadRequest, sendErrInvalid, "view_seconds only applies to snaps")
+	}
+
+	// Image messages and snaps carry the ID of an upload from POST /api/media
+	if req.Type == "image" || req.Type == "snap" {
+		if sendErr := imageMedia(user, req.Content); sendErr != nil {
+			return nil, false, sendErr
+		}
+	}
+
+	// Disappearing messages count down from when the receiver reads them
+	var disappearAfter time.Duration
+	if req.Disappear {
+		if req.ConversationID != 0 {
+			return nil, false, newSendError(http.StatusBadRequest, sendErrInvalid, "Disappearing messages can only be sent in direct messages")
+		}
+		if req.DisappearAfter == 0 {
+			req.DisappearAfter = defaultDisappearSeconds
+		}
+		if req.DisappearAfter < 0 || req.DisappearAfter > maxDisappearSeconds {
+			return nil, false, newSendError(http.StatusBadRequest, sendErrInvalid, "disappear_seconds is out of range")
+		}
+		disappearAfter = time.Duration(req.DisappearAfter) * time.Second
+	}
+
+	// Only members may post to a group, and checking first keeps reply_to_id
+	// from revealing what another group contains
+	if req.ConversationID != 0 {
+		if _, err := database.GetGroupMember(req.ConversationID, user.ID); err != nil {
+			return nil, false, newSendError(http.StatusNotFound, sendErrNotFound, "Group not found")
+		}
+	}
+
+	if req.ReplyToID != 0 {
+		if req.Type == "snap" {
+			return nil, false, newSendError(http.StatusBadRequest, sendErrInvalid, "Snaps can't quote other messages")
+		}
+		if sendErr := checkReplyParent(user, req); sendErr != nil {
+			return nil, false, sendErr
+		}
+	}
+
+	if req.ConversationID != 0 {
+		return sendGroupMessage(user, deviceID, req)
+	}
+
 	// Check if receiver exists
 	receiver, err := database.GetUserByID(req.ReceiverID)
 	if err != nil {
-		http.Error(w, `{"error": "Recipient not found"}`, http.StatusNotFound)
-		return
+		return nil, false, newSendError(http.StatusNotFound, sendErrNotFound, "Recipient not found")
 	}
 
-	// Set expiration for disappearing messages (24 hours if not read)
-	var expiresAt *time.Time
-	if req.Disappear {
-		t := time.Now().Add(24 * time.Hour)
-		expiresAt = &t
+	if req.Type == "snap" {
+		message, err = database.CreateSnap(user.ID, receiver.ID, req.Content, req.ViewSeconds, req.ClientID)
+	} else {
+		message, err = database.CreateMessage(user.ID, receiver.ID, req.Content, req.Type, disappearAfter, req.ClientID, req.ReplyToID)
+	}
+	if errors.Is(err, database.ErrBlocked) {
+		return nil, false, newSendError(http.StatusForbidden, sendErrForbidden, "You can't message this user")
 	}
-
-	message, err := database.CreateMessage(user.ID, receiver.ID, req.Content, req.Type, expiresAt)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to send message"}`, http.StatusInternalServerError)
-		return
+		return duplicateSend(user, req.ClientID)
+	}
+	if sendErr := attachImageMedia(message); sendErr != nil {
+		return nil, false, sendErr
 	}
+	fillReplyPreview(message)
 
-	// Broadcast via WebSocket
-	BroadcastMessage(receiver.ID, models.WebSocketMessage{
+	// Broadcast via WebSocket, to the sender's other devices too
+	recipients := []int64{receiver.ID}
+	if receiver.ID != user.ID {
+		recipients = append(recipients, user.ID)
+	}
+	broadcastFromDevice(recipients, user.ID, deviceID, models.WebSocketMessage{
 		Type: "message",
 		Payload: models.MessageWithSender{
 			Message:        *message,
 			SenderUsername: user.Username,
-			SenderAvatar:   user.Avatar,
+			SenderAvatar:   models.AvatarURL(user.ID, user.Avatar),
 		},
 	})
 
-	json.NewEncoder(w).Encode(message)
+	return message, false, nil
+}
+
+// checkReplyParent checks that the message a send quotes is visible and in the
+// conversation being sent to. Anything else reads as not found, so a reply
+// can't probe other conversations.
+func checkReplyParent(user *models.User, req sendMessageRequest) *sendError {
+	parent, err := database.GetMessageByID(req.ReplyToID)
+	if err != nil || (parent.ExpiresAt != nil && parent.ExpiresAt.Before(time.Now())) {
+		return newSendError(http.StatusNotFound, sendErrNotFound, "Replied-to message not found")
+	}
+
+	var sameConversation bool
+	if req.ConversationID != 0 {
+		sameConversation = parent.ConversationID == req.ConversationID
+	} else {
+		sameConversation = parent.ConversationID == 0 &&
+			((parent.SenderID == user.ID && parent.ReceiverID == req.ReceiverID) ||
+				(parent.SenderID == req.ReceiverID && parent.ReceiverID == user.ID))
+	}
+	if !sameConversation {
+		return newSendError(http.StatusNotFound, sendErrNotFound, "Replied-to message not found")
+	}
+	return nil
+}
+
+// fillReplyPreview quotes the parent of a single reply, leaving the message as
+// is if the preview can't be loaded
+func fillReplyPreview(message *models.Message) {
+	if err := database.FillReplyPreviews(message); err != nil {
+		log.Printf("Error loading reply preview: %v", err)
+	}
+}
+
+// duplicateSend handles a failed insert: if a concurrent retry with the same
+// client ID won the race, its message is returned as a duplicate
+func duplicateSend(user *models.User, clientID string) (*models.Message, bool, *sendError) {
+	if clientID != "" {
+		if existing, err := database.GetMessageByClientID(user.ID, clientID); err == nil {
+			return existing, true, nil
+		}
+	}
+	return nil, false, newSendError(http.StatusInternalServerError, sendErrInternal, "Failed to send message")
 }
 
-// MarkAsRead marks messages from a user as read
+// MarkAsRead marks messages from a user as read, up to and including an
+// optional up_to_id, and tells the sender which messages were read
 func MarkAsRead(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
@@ -170,19 +425,220 @@ func MarkAsRead(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := database.MarkMessagesAsRead(senderID, user.ID); err != nil {
+	// The body is optional; without up_to_id everything is marked read
+	var req struct {
+		UpToID int64 `json:"up_to_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	if req.UpToID < 0 {
+		http.Error(w, `{"error": "Invalid up_to_id"}`, http.StatusBadRequest)
+		return
+	}
+
+	receipt, err := database.MarkMessagesAsRead(senderID, user.ID, req.UpToID)
+	if err != nil {
 		http.Error(w, `{"error": "Failed to mark as read"}`, http.StatusInternalServerError)
 		return
 	}
 
 	// Notify sender that messages were read
-	BroadcastMessage(senderID, models.WebSocketMessage{
-		Type: "read",
-		Payload: map[string]int64{
-			"reader_id": user.ID,
+	sendReadReceipt(user, receipt)
+
+	if receipt.MessageIDs == nil {
+		receipt.MessageIDs = []int64{}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":     true,
+		"message_ids": receipt.MessageIDs,
+	})
+}
+
+// messageParticipants returns everyone who can see a message
+func messageParticipants(msg *models.Message) []int64 {
+	if msg.ConversationID != 0 {
+		memberIDs, err := database.GetGroupMemberIDs(msg.ConversationID)
+		if err != nil {
+			return nil
+		}
+		return memberIDs
+	}
+	return []int64{msg.SenderID, msg.ReceiverID}
+}
+
+// visibleMessage loads the {id} message and checks that the current user
+// takes part in its conversation, writing an error response and returning nil
+// otherwise. It also returns the conversation's participants.
+func visibleMessage(w http.ResponseWriter, r *http.Request, user *models.User) (*models.Message, []int64) {
+	messageID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, `{"error": "Invalid message ID"}`, http.StatusBadRequest)
+		return nil, nil
+	}
+
+	message, err := database.GetMessageByID(messageID)
+	if err != nil || (message.ExpiresAt != nil && message.ExpiresAt.Before(time.Now())) {
+		http.Error(w, `{"error": "Message not found"}`, http.StatusNotFound)
+		return nil, nil
+	}
+	participants := messageParticipants(message)
+	for _, id := range participants {
+		if id == user.ID {
+			return message, participants
+		}
+	}
+	http.Error(w, `{"error": "Message not found"}`, http.StatusNotFound)
+	return nil, nil
+}
+
+// ownMessage loads the {id} message and checks that the current user sent it,
+// writing an error response and returning nil otherwise
+func ownMessage(w http.ResponseWriter, r *http.Request, user *models.User) *models.Message {
+	messageID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, `{"error": "Invalid message ID"}`, http.StatusBadRequest)
+		return nil
+	}
+
+	message, err := database.GetMessageByID(messageID)
+	if err != nil || (message.ExpiresAt != nil && message.ExpiresAt.Before(time.Now())) {
+		http.Error(w, `{"error": "Message not found"}`, http.StatusNotFound)
+		return nil
+	}
+
+	if message.SenderID != user.ID {
+		http.Error(w, `{"error": "You can only change your own messages"}`, http.StatusForbidden)
+		return nil
+	}
+	return message
+}
+
+// EditMessage changes the content of one of the current user's text messages
+func EditMessage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user := middleware.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var req editMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	if strings.TrimSpace(req.Content) == "" {
+		http.Error(w, `{"error": "Message content is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	message := ownMessage(w, r, user)
+	if message == nil {
+		return
+	}
+
+	if message.Type != "text" {
+		http.Error(w, `{"error": "Only text messages can be edited"}`, http.StatusBadRequest)
+		return
+	}
+
+	updated, err := database.UpdateMessageContent(message.ID, req.Content)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to edit message"}`, http.StatusInternalServerError)
+		return
+	}
+
+	fillReplyPreview(updated)
+	hideReadReceipts(user.ID, updated)
+	BroadcastToUsers(messageParticipants(updated), 0, models.WebSocketMessage{
+		Type:    "message_edited",
+		Payload: updated,
+	})
+
+	json.NewEncoder(w).Encode(updated)
+}
+
+// DeleteMessage removes one of the current user's messages
+func DeleteMessage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user := middleware.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	message := ownMessage(w, r, user)
+	if message == nil {
+		return
+	}
+
+	// Resolve recipients before the row disappears
+	participants := messageParticipants(message)
+
+	if err := database.DeleteMessage(message.ID); err != nil {
+		http.Error(w, `{"error": "Failed to delete message"}`, http.StatusInternalServerError)
+		return
+	}
+	DiscardMessageMedia([]models.Message{*message})
+
+	BroadcastToUsers(participants, 0, models.WebSocketMessage{
+		Type: "message_deleted",
+		Payload: map[string]interface{}{
+			"id":              message.ID,
+			"sender_id":       message.SenderID,
+			"receiver_id":     message.ReceiverID,
+			"conversation_id": message.ConversationID,
 		},
 	})
 
 	json.NewEncoder(w).Encode(map[string]bool{"success": true})
 }
 
+// GetThread returns the reply chain a message belongs to, from its oldest
+// surviving ancestor down through every reply, in chronological order
+func GetThread(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user := middleware.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	message, _ := visibleMessage(w, r, user)
+	if message == nil {
+		return
+	}
+
+	limit := 100
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 200 {
+			limit = parsed
+		}
+	}
+
+	messages, hasMore, err := database.GetThread(message.ID, limit)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to get thread"}`, http.StatusInternalServerError)
+		return
+	}
+
+	ptrs := make([]*models.Message, len(messages))
+	for i := range messages {
+		ptrs[i] = &messages[i].Message
+	}
+	hideReadReceipts(user.ID, ptrs...)
+
+	if messages == nil {
+		messages = []models.MessageWithSender{}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"messages": messages,
+		"has_more": hasMore,
+	})
+}
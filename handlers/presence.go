@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"scuffedsnap/database"
+	"scuffedsnap/middleware"
+	"scuffedsnap/models"
+)
+
+// SetPresence handles PUT /api/me/presence, choosing online, away or invisible
+func SetPresence(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user := middleware.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Status models.Presence `json:"status"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	if !req.Status.Selectable() {
+		http.Error(w, `{"error": "Status must be online, away or invisible"}`, http.StatusBadRequest)
+		return
+	}
+
+	// Freeze last seen at the moment the user disappears from view
+	if req.Status == models.PresenceInvisible && user.Presence != models.PresenceInvisible {
+		if err := database.TouchLastSeen(user.ID, time.Now()); err != nil {
+			log.Printf("Error updating last seen: %v", err)
+		}
+	}
+	if err := database.SetUserPresence(user.ID, req.Status); err != nil {
+		http.Error(w, `{"error": "Failed to update presence"}`, http.StatusInternalServerError)
+		return
+	}
+
+	connected := IsUserOnline(user.ID)
+	if before, after := user.Presence.Visible(connected), req.Status.Visible(connected); before != after {
+		notifyFriendsOfPresence(user.ID, after)
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"status":  req.Status,
+	})
+}
+
+// presenceEvent builds the online_status event friends receive about a user
+func presenceEvent(userID int64, status models.Presence, lastSeen *time.Time) models.WebSocketMessage {
+	payload := map[string]interface{}{
+		"user_id": userID,
+		"online":  status != models.PresenceOffline,
+		"status":  status,
+	}
+	if status == models.PresenceOffline && lastSeen != nil {
+		payload["last_seen"] = lastSeen
+	}
+	return models.WebSocketMessage{Type: "online_status", Payload: payload}
+}
+
+// notifyFriendsOfPresence sends a user's visible status to their friends on
+// every instance
+func notifyFriendsOfPresence(userID int64, status models.Presence) {
+	friendIDs, err := database.GetFriendIDs(userID)
+	if err != nil {
+		log.Printf("Error loading friends for presence: %v", err)
+		return
+	}
+	var lastSeen *time.Time
+	if status == models.PresenceOffline {
+		if stored, err := database.GetUserPresence([]int64{userID}); err == nil {
+			lastSeen = stored[userID].LastSeen
+		}
+	}
+	BroadcastToUsers(friendIDs, userID, presenceEvent(userID, status, lastSeen))
+}
+
+// fillPresence sets the online status shown to viewerID for each user. Only
+// friends' presence is visible; everyone else appears offline.
+func fillPresence(viewerID int64, users ...*models.UserResponse) {
+	visible := map[int64]bool{viewerID: true}
+	if friendIDs, err := database.GetFriendIDs(viewerID); err == nil {
+		for _, id := range friendIDs {
+			visible[id] = true
+		}
+	} else {
+		log.Printf("Error loading friends for presence: %v", err)
+	}
+
+	var ids []int64
+	for _, u := range users {
+		if visible[u.ID] {
+			ids = append(ids, u.ID)
+		}
+	}
+	stored, err := database.GetUserPresence(ids)
+	if err != nil {
+		log.Printf("Error loading presence: %v", err)
+	}
+
+	for _, u := range users {
+		u.Online, u.Status, u.LastSeen = false, "", nil
+		p, ok := stored[u.ID]
+		if !ok {
+			continue
+		}
+		u.Status = p.Presence.Visible(IsUserOnline(u.ID))
+		u.Online = u.Status != models.PresenceOffline
+		if !u.Online {
+			u.LastSeen = p.LastSeen
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+
+	"scuffedsnap/database"
+	"scuffedsnap/middleware"
+	"scuffedsnap/models"
+)
+
+// maxEmojiBytes bounds a reaction's length; the longest ZWJ sequences are
+// around 35 bytes
+const maxEmojiBytes = 64
+
+// validEmoji reports whether s looks like a single emoji or emoji sequence:
+// at least one symbol, and no letters, digits, spaces or control characters
+func validEmoji(s string) bool {
+	if s == "" || len(s) > maxEmojiBytes || !utf8.ValidString(s) {
+		return false
+	}
+	symbol := false
+	for _, r := range s {
+		switch {
+		case unicode.Is(unicode.So, r):
+			symbol = true
+		case unicode.IsLetter(r), unicode.IsDigit(r), unicode.IsSpace(r), unicode.IsControl(r), unicode.IsPunct(r):
+			return false
+		}
+	}
+	return symbol
+}
+
+// SetReaction handles PUT and DELETE /api/messages/{id}/reactions/{emoji},
+// adding or removing the current user's reaction. Both participants of a
+// direct message, or every member of a group, get a reaction event.
+func SetReaction(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user := middleware.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	// Only people who can see the message may react to it
+	message, participants := visibleMessage(w, r, user)
+	if message == nil {
+		return
+	}
+	emoji := mux.Vars(r)["emoji"]
+	if !validEmoji(emoji) {
+		http.Error(w, `{"error": "Invalid emoji"}`, http.StatusBadRequest)
+		return
+	}
+
+	var (
+		changed bool
+		err     error
+	)
+	adding := r.Method != http.MethodDelete
+	if adding {
+		changed, err = database.AddReaction(message.ID, user.ID, emoji)
+	} else {
+		changed, err = database.RemoveReaction(message.ID, user.ID, emoji)
+	}
+	switch {
+	case errors.Is(err, database.ErrBlocked):
+		http.Error(w, `{"error": "Cannot react to this message"}`, http.StatusForbidden)
+		return
+	case errors.Is(err, database.ErrTooManyReactions):
+		http.Error(w, `{"error": "Too many reactions on this message"}`, http.StatusConflict)
+		return
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, `{"error": "Message not found"}`, http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, `{"error": "Failed to update reaction"}`, http.StatusInternalServerError)
+		return
+	}
+
+	reactions, err := database.GetReactions([]int64{message.ID})
+	if err != nil {
+		http.Error(w, `{"error": "Failed to load reactions"}`, http.StatusInternalServerError)
+		return
+	}
+	summaries := reactions[message.ID]
+	if summaries == nil {
+		summaries = []models.ReactionSummary{}
+	}
+
+	// Repeating an add or remove changes nothing and tells no one
+	if changed {
+		BroadcastToUsers(participants, 0, models.WebSocketMessage{
+			Type: "reaction",
+			Payload: models.ReactionEvent{
+				MessageID: message.ID,
+				UserID:    user.ID,
+				Emoji:     emoji,
+				Added:     adding,
+				Reactions: summaries,
+			},
+		})
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message_id": message.ID,
+		"reactions":  summaries,
+	})
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"scuffedsnap/database"
+	"scuffedsnap/middleware"
+	"scuffedsnap/models"
+)
+
+// maxReceiptIDs bounds how many messages one delivered frame can acknowledge
+const maxReceiptIDs = 500
+
+// SetPrivacy handles PUT /api/me/privacy. With read receipts off, senders
+// are no longer told when the user reads their messages.
+func SetPrivacy(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user := middleware.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		ReadReceipts *bool `json:"read_receipts"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	if req.ReadReceipts == nil {
+		http.Error(w, `{"error": "read_receipts is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	if err := database.SetReadReceipts(user.ID, *req.ReadReceipts); err != nil {
+		http.Error(w, `{"error": "Failed to update privacy settings"}`, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":       true,
+		"read_receipts": *req.ReadReceipts,
+	})
+}
+
+// sendDeliveredReceipts tells each sender which of their messages reached
+// the receiver
+func sendDeliveredReceipts(receipts []models.Receipt) {
+	for _, receipt := range receipts {
+		BroadcastMessage(receipt.SenderID, models.WebSocketMessage{
+			Type: "delivered",
+			Payload: map[string]interface{}{
+				"receiver_id":  receipt.ReceiverID,
+				"message_ids":  receipt.MessageIDs,
+				"delivered_at": receipt.At,
+			},
+		})
+	}
+}
+
+// sendReadReceipt tells the sender which messages the reader just read,
+// unless the reader turned read receipts off. A long backlog is split across
+// events of at most maxReceiptIDs messages.
+func sendReadReceipt(reader *models.User, receipt *models.Receipt) {
+	if !reader.ReadReceipts {
+		return
+	}
+	for ids := receipt.MessageIDs; len(ids) > 0; {
+		chunk := ids
+		if len(chunk) > maxReceiptIDs {
+			chunk = chunk[:maxReceiptIDs]
+		}
+		ids = ids[len(chunk):]
+
+		BroadcastMessage(receipt.SenderID, models.WebSocketMessage{
+			Type: "read",
+			Payload: map[string]interface{}{
+				"reader_id":   receipt.ReceiverID,
+				"message_ids": chunk,
+				"read_at":     receipt.At,
+			},
+		})
+	}
+}
+
+// hideReadReceipts clears read_at on viewerID's own direct messages whose
+// receivers turned read receipts off. A disappearing message's expiry is read
+// time plus its timer, so that is cleared too.
+func hideReadReceipts(viewerID int64, messages ...*models.Message) {
+	var receiverIDs []int64
+	for _, msg := range messages {
+		if msg.SenderID == viewerID && msg.ReceiverID != 0 && msg.ReadAt != nil {
+			receiverIDs = append(receiverIDs, msg.ReceiverID)
+		}
+	}
+	if len(receiverIDs) == 0 {
+		return
+	}
+
+	disabled, err := database.GetReadReceiptsDisabled(receiverIDs)
+	if err != nil {
+		log.Printf("Error loading read receipt settings: %v", err)
+	}
+	for _, msg := range messages {
+		// Fail closed if the settings couldn't be loaded
+		if msg.SenderID == viewerID && msg.ReceiverID != 0 && (err != nil || disabled[msg.ReceiverID]) {
+			msg.ReadAt = nil
+			if msg.DisappearSeconds > 0 {
+				msg.ExpiresAt = nil
+			}
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"scuffedsnap/database"
+)
+
+func TestHiddenReceiptsHideDisappearingExpiry(t *testing.T) {
+	sender, err := database.CreateUserWithAuth("hidden_receipt_sender", "hidden_receipt_sender@example.com", "x", "email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := database.CreateUserWithAuth("hidden_receipt_reader", "hidden_receipt_reader@example.com", "x", "email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent, err := database.CreateMessage(sender.ID, reader.ID, "gone soon", "text", time.Minute, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.MarkMessagesAsRead(sender.ID, reader.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// With receipts on, the sender sees both
+	msg, err := database.GetMessageByID(sent.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hideReadReceipts(sender.ID, msg)
+	if msg.ReadAt == nil || msg.ExpiresAt == nil {
+		t.Fatalf("receipts on: read_at = %v, expires_at = %v", msg.ReadAt, msg.ExpiresAt)
+	}
+
+	if err := database.SetReadReceipts(reader.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	msg, err = database.GetMessageByID(sent.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hideReadReceipts(sender.ID, msg)
+	if msg.ReadAt != nil || msg.ExpiresAt != nil {
+		t.Fatalf("receipts off: read_at = %v, expires_at = %v", msg.ReadAt, msg.ExpiresAt)
+	}
+
+	// The reader still sees their own countdown
+	msg, err = database.GetMessageByID(sent.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hideReadReceipts(reader.ID, msg)
+	if msg.ExpiresAt == nil {
+		t.Fatal("reader lost the expiry of a message they read")
+	}
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"scuffedsnap/database"
+	"scuffedsnap/middleware"
+	"scuffedsnap/models"
+	"scuffedsnap/retention"
+)
+
+// maxRetentionDays bounds per-conversation retention overrides
+const maxRetentionDays = 3650
+
+// retentionPolicy is the operator's global policy; set with SetRetentionPolicy
+var retentionPolicy retention.Policy
+
+// SetRetentionPolicy sets the global policy that user-set overrides may
+// shorten but not extend
+func SetRetentionPolicy(p retention.Policy) {
+	retentionPolicy = p
+}
+
+type retentionRequest struct {
+	RetentionDays int `json:"retention_days"` // 0 keeps messages forever
+}
+
+// decodeRetention reads a retention override body, writing an error response
+// and returning ok=false if it is invalid. With a global policy in force, an
+// override can only shorten it.
+func decodeRetention(w http.ResponseWriter, r *http.Request) (int, bool) {
+	var req retentionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return 0, false
+	}
+	minDays, maxDays := 0, maxRetentionDays
+	if retentionPolicy.Days > 0 {
+		minDays, maxDays = 1, retentionPolicy.Days
+	}
+	if req.RetentionDays < minDays || req.RetentionDays > maxDays {
+		http.Error(w, fmt.Sprintf(`{"error": "retention_days must be between %d and %d"}`, minDays, maxDays), http.StatusBadRequest)
+		return 0, false
+	}
+	return req.RetentionDays, true
+}
+
+// SetGroupRetention overrides the retention policy for a group. Only the
+// owner and admins can change it.
+func SetGroupRetention(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user := middleware.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	groupID, member, ok := groupMembership(w, r, user)
+	if !ok {
+		return
+	}
+	if !member.Role.CanManage() {
+		http.Error(w, `{"error": "Only the owner or an admin can change retention"}`, http.StatusForbidden)
+		return
+	}
+
+	override := models.GroupRetention(groupID, 0)
+	if r.Method == http.MethodDelete {
+		if err := database.DeleteRetentionOverride(override); err != nil {
+			http.Error(w, `{"error": "Failed to reset retention"}`, http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]bool{"success": true})
+		return
+	}
+
+	days, ok := decodeRetention(w, r)
+	if !ok {
+		return
+	}
+	override.RetentionDays = days
+	if err := database.SetRetentionOverride(override); err != nil {
+		http.Error(w, `{"error": "Failed to set retention"}`, http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(override)
+}
+
+// SetDirectRetention overrides the retention policy for the current user's
+// direct chat with {userId}. Either participant can change it, and the other
+// is told.
+func SetDirectRetention(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user := middleware.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	otherUserID, err := strconv.ParseInt(mux.Vars(r)["userId"], 10, 64)
+	if err != nil || otherUserID == user.ID {
+		http.Error(w, `{"error": "Invalid user ID"}`, http.StatusBadRequest)
+		return
+	}
+	if _, err := database.GetUserByID(otherUserID); err != nil {
+		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+		return
+	}
+
+	override := models.DirectRetention(user.ID, otherUserID, 0)
+	if r.Method == http.MethodDelete {
+		if err := database.DeleteRetentionOverride(override); err != nil {
+			http.Error(w, `{"error": "Failed to reset retention"}`, http.StatusInternalServerError)
+			return
+		}
+		notifyDirectRetention(user.ID, otherUserID, nil)
+		json.NewEncoder(w).Encode(map[string]bool{"success": true})
+		return
+	}
+
+	days, ok := decodeRetention(w, r)
+	if !ok {
+		return
+	}
+	override.RetentionDays = days
+	if err := database.SetRetentionOverride(override); err != nil {
+		http.Error(w, `{"error": "Failed to set retention"}`, http.StatusInternalServerError)
+		return
+	}
+	notifyDirectRetention(user.ID, otherUserID, override)
+	json.NewEncoder(w).Encode(override)
+}
+
+// notifyDirectRetention tells the other participant of a direct chat that
+// its retention changed; a nil override means it follows the global policy
+// again
+func notifyDirectRetention(changedBy, otherUserID int64, override *models.RetentionOverride) {
+	payload := map[string]interface{}{"user_id": changedBy}
+	if override != nil {
+		payload["retention_days"] = override.RetentionDays
+	}
+	BroadcastMessage(otherUserID, models.WebSocketMessage{
+		Type:    "retention_changed",
+		Payload: payload,
+	})
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"encoding/json"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"scuffedsnap/database"
+	"scuffedsnap/middleware"
+	"scuffedsnap/models"
+)
+
+// highlightHTML turns the store's match markers into <mark> tags once the
+// excerpt has been escaped
+var highlightHTML = strings.NewReplacer("\x01", "<mark>", "\x02", "</mark>")
+
+// parseSearchTime accepts an RFC 3339 timestamp or a bare date
+func parseSearchTime(s string) (*time.Time, error) {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		if t, err = time.Parse("2006-01-02", s); err != nil {
+			return nil, err
+		}
+	}
+	return &t, nil
+}
+
+// SearchMessages searches the text messages in the current user's
+// conversations, most relevant first
+func SearchMessages(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user := middleware.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	search := models.MessageSearch{Query: strings.TrimSpace(query.Get("q")), Limit: 20}
+	if search.Query == "" {
+		http.Error(w, `{"error": "Search query is required"}`, http.StatusBadRequest)
+		return
+	}
+	if l := query.Get("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 50 {
+			search.Limit = parsed
+		}
+	}
+	if o := query.Get("offset"); o != "" {
+		parsed, err := strconv.Atoi(o)
+		if err != nil || parsed < 0 {
+			http.Error(w, `{"error": "Invalid offset"}`, http.StatusBadRequest)
+			return
+		}
+		search.Offset = parsed
+	}
+
+	for param, dest := range map[string]*int64{
+		"user_id":         &search.WithUserID,
+		"conversation_id": &search.ConversationID,
+		"sender_id":       &search.SenderID,
+	} {
+		if v := query.Get(param); v != "" {
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || id <= 0 {
+				http.Error(w, `{"error": "Invalid `+param+`"}`, http.StatusBadRequest)
+				return
+			}
+			*dest = id
+		}
+	}
+	if search.WithUserID != 0 && search.ConversationID != 0 {
+		http.Error(w, `{"error": "Use either user_id or conversation_id, not both"}`, http.StatusBadRequest)
+		return
+	}
+
+	for param, dest := range map[string]**time.Time{"from": &search.From, "to": &search.To} {
+		if v := query.Get(param); v != "" {
+			t, err := parseSearchTime(v)
+			if err != nil {
+				http.Error(w, `{"error": "Invalid `+param+` date"}`, http.StatusBadRequest)
+				return
+			}
+			*dest = t
+		}
+	}
+
+	results, hasMore, err := database.SearchMessages(user.ID, search)
+	if err != nil {
+		http.Error(w, `{"error": "Search failed"}`, http.StatusInternalServerError)
+		return
+	}
+
+	messages := make([]*models.Message, len(results))
+	for i := range results {
+		results[i].Highlight = highlightHTML.Replace(html.EscapeString(results[i].Highlight))
+		messages[i] = &results[i].Message
+	}
+	hideReadReceipts(user.ID, messages...)
+
+	if results == nil {
+		results = []models.MessageSearchResult{}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"results":  results,
+		"has_more": hasMore,
+	})
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"scuffedsnap/database"
+	"scuffedsnap/middleware"
+	"scuffedsnap/models"
+)
+
+// maxSnapViewSeconds caps how long an opened snap stays viewable
+const maxSnapViewSeconds = 60
+
+// OpenSnap releases a snap's image to its recipient. Without a viewing
+// duration the snap is destroyed as soon as it has been read; with one, the
+// recipient can re-open it until the duration runs out and it is destroyed.
+func OpenSnap(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user := middleware.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	messageID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, `{"error": "Invalid message ID"}`, http.StatusBadRequest)
+		return
+	}
+
+	message, err := database.GetMessageByID(messageID)
+	if err != nil || message.Type != "snap" || message.ReceiverID != user.ID {
+		http.Error(w, `{"error": "Snap not found"}`, http.StatusNotFound)
+		return
+	}
+
+	firstOpen, err := database.OpenSnap(message.ID, user.ID)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to open snap"}`, http.StatusInternalServerError)
+		return
+	}
+
+	if firstOpen {
+		if message, err = database.GetMessageByID(messageID); err != nil {
+			http.Error(w, `{"error": "Failed to open snap"}`, http.StatusInternalServerError)
+			return
+		}
+
+		BroadcastMessage(message.SenderID, models.WebSocketMessage{
+			Type: "opened",
+			Payload: map[string]interface{}{
+				"message_id": message.ID,
+				"opened_by":  user.ID,
+				"opened_at":  message.OpenedAt,
+			},
+		})
+
+		if message.ViewSeconds > 0 {
+			snap := *message
+			time.AfterFunc(time.Duration(message.ViewSeconds)*time.Second, func() {
+				destroySnap(&snap)
+			})
+		}
+	} else if !snapViewable(message) {
+		destroySnap(message)
+		http.Error(w, `{"error": "Snap has already been opened"}`, http.StatusGone)
+		return
+	}
+
+	upload, err := database.GetMedia(message.Content)
+	if err != nil {
+		http.Error(w, `{"error": "Snap has already been opened"}`, http.StatusGone)
+		return
+	}
+
+	blob, err := mediaStore.Get(upload.ID)
+	if err != nil {
+		http.Error(w, `{"error": "Snap has already been opened"}`, http.StatusGone)
+		return
+	}
+	data, err := io.ReadAll(blob)
+	blob.Close()
+	if err != nil {
+		http.Error(w, `{"error": "Failed to open snap"}`, http.StatusInternalServerError)
+		return
+	}
+
+	if message.ViewSeconds == 0 {
+		destroySnap(message)
+	} else {
+		viewableUntil := message.OpenedAt.Add(time.Duration(message.ViewSeconds) * time.Second)
+		w.Header().Set("X-Snap-Viewable-Until", viewableUntil.UTC().Format(time.RFC3339))
+	}
+
+	w.Header().Set("Content-Type", upload.MimeType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(data)
+}
+
+// snapViewable reports whether an opened snap is still inside its viewing window
+func snapViewable(message *models.Message) bool {
+	if message.Content == "" || message.OpenedAt == nil || message.ViewSeconds == 0 {
+		return false
+	}
+	return time.Since(*message.OpenedAt) < time.Duration(message.ViewSeconds)*time.Second
+}
+
+// destroySnap deletes a snap's image, keeping the message as a record that it was opened
+func destroySnap(message *models.Message) {
+	if message.Content == "" {
+		return
+	}
+	if err := database.ClearSnap(message.ID); err != nil {
+		return
+	}
+	if mediaStore != nil {
+		mediaStore.Delete(message.Content)
+	}
+}
@@ -4,10 +4,15 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"strconv"
 	"sync"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 
+	"scuffedsnap/broker"
+	"scuffedsnap/database"
 	"scuffedsnap/middleware"
 	"scuffedsnap/models"
 )
@@ -20,17 +25,130 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
-// Client represents a WebSocket client
+// maxDeviceIDLength bounds client-supplied device IDs
+const maxDeviceIDLength = 64
+
+const (
+	// sendQueueSize bounds the frames waiting for a client's writer. A client
+	// that lets it fill is too slow to keep up and is evicted.
+	sendQueueSize = 256
+	// maxFrameSize caps frames read from clients
+	maxFrameSize = 64 * 1024
+)
+
+// Connection timing; variables so tests can shorten them
+var (
+	// writeWait bounds a single frame write
+	writeWait = 10 * time.Second
+	// pongWait is how long a connection may stay silent before it is dropped
+	pongWait = 60 * time.Second
+	// pingPeriod must be shorter than pongWait so pongs arrive in time
+	pingPeriod = pongWait * 9 / 10
+)
+
+// Client represents one WebSocket connection. A user may have several, one per device.
 type Client struct {
-	ID     int64
-	Conn   *websocket.Conn
-	Send   chan []byte
-	UserID int64
+	ID       int64
+	DeviceID string
+	Conn     *websocket.Conn
+	Send     chan []byte
+	UserID   int64
+
+	mu          sync.Mutex // Guards Send against sends after close
+	closed      bool
+	closeCode   int
+	closeReason string
+	replaying   bool        // Logged events are held until replay finishes
+	held        []heldEvent // Logged events that arrived during replay
+}
+
+// heldEvent is a logged event delivered while its client was replaying
+type heldEvent struct {
+	seq  int64
+	data []byte
+}
+
+// enqueue queues a frame for the client's writer. A client whose queue is
+// full is evicted rather than allowed to hold up everyone else.
+func (c *Client) enqueue(data []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return false
+	}
+	select {
+	case c.Send <- data:
+		return true
+	default:
+		log.Printf("Evicting slow client: UserID %d, device %s", c.UserID, c.DeviceID)
+		c.closeLocked(websocket.CloseTryAgainLater, "send queue full")
+		return false
+	}
+}
+
+// enqueueEvent queues a logged event, holding it back while the client is
+// still replaying older events so the log is delivered in order
+func (c *Client) enqueueEvent(seq int64, data []byte) bool {
+	c.mu.Lock()
+	if c.replaying && !c.closed {
+		if len(c.held) >= sendQueueSize {
+			log.Printf("Evicting slow client: UserID %d, device %s", c.UserID, c.DeviceID)
+			c.closeLocked(websocket.CloseTryAgainLater, "send queue full")
+		} else {
+			c.held = append(c.held, heldEvent{seq: seq, data: data})
+		}
+		c.mu.Unlock()
+		return false
+	}
+	c.mu.Unlock()
+	return c.enqueue(data)
+}
+
+// finishReplay releases events held during replay, skipping any the replay
+// already sent (those at or below lastSeq)
+func (c *Client) finishReplay(lastSeq int64) {
+	c.mu.Lock()
+	held := c.held
+	c.held = nil
+	c.replaying = false
+	c.mu.Unlock()
+
+	for _, event := range held {
+		if event.seq > lastSeq {
+			c.enqueue(event.data)
+		}
+	}
+}
+
+// close stops the client's writer, which sends a close frame with code and
+// reason and then drops the connection. Later calls are no-ops.
+func (c *Client) close(code int, reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closeLocked(code, reason)
+}
+
+func (c *Client) closeLocked(code int, reason string) {
+	if c.closed {
+		return
+	}
+	c.closed = true
+	c.closeCode = code
+	c.closeReason = reason
+	close(c.Send)
 }
 
-// Hub maintains the set of active clients
+// isClosed reports whether the client has been closed
+func (c *Client) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// Hub maintains the set of active clients. Only RunHub adds or removes
+// clients; closing a client makes its readPump unregister it.
 type Hub struct {
-	clients    map[int64]*Client // userID -> client
+	clients    map[int64]map[string]*Client // userID -> deviceID -> client
 	register   chan *Client
 	unregister chan *Client
 	broadcast  chan BroadcastPayload
@@ -40,48 +158,80 @@ type Hub struct {
 type BroadcastPayload struct {
 	UserID  int64
 	Message []byte
+	Seq     int64 // Event log position, 0 for ephemeral events
+	// ExceptDevice is a device of UserID that already has the message
+	ExceptDevice string
 }
 
 var hub = &Hub{
-	clients:    make(map[int64]*Client),
+	clients:    make(map[int64]map[string]*Client),
 	register:   make(chan *Client),
 	unregister: make(chan *Client),
 	broadcast:  make(chan BroadcastPayload, 256),
 }
 
-// RunHub starts the WebSocket hub
+// RunHub starts the WebSocket hub and subscribes it to the broker
 func RunHub() {
+	if bus == nil {
+		bus = broker.NewMemory()
+	}
+	bus.Subscribe(handleBrokerEvent)
+	go runPresence()
+
 	for {
 		select {
 		case client := <-hub.register:
 			hub.mutex.Lock()
-			hub.clients[client.UserID] = client
+			devices := hub.clients[client.UserID]
+			firstDevice := len(devices) == 0
+			if devices == nil {
+				devices = make(map[string]*Client)
+				hub.clients[client.UserID] = devices
+			}
+			// A reconnect from the same device replaces its stale connection
+			if old, ok := devices[client.DeviceID]; ok {
+				old.close(websocket.CloseNormalClosure, "replaced by a new connection")
+			}
+			devices[client.DeviceID] = client
 			hub.mutex.Unlock()
-			log.Printf("Client connected: UserID %d", client.UserID)
+			log.Printf("Client connected: UserID %d, device %s", client.UserID, client.DeviceID)
 
-			// Broadcast online status to friends
-			broadcastOnlineStatus(client.UserID, true)
+			// Broadcast online status to friends when the first device connects
+			if firstDevice {
+				localPresenceChanged(client.UserID, true)
+			}
 
 		case client := <-hub.unregister:
 			hub.mutex.Lock()
-			if _, ok := hub.clients[client.UserID]; ok {
-				delete(hub.clients, client.UserID)
-				close(client.Send)
+			lastDevice := false
+			devices := hub.clients[client.UserID]
+			// Only remove the connection if it hasn't already been replaced
+			if devices[client.DeviceID] == client {
+				delete(devices, client.DeviceID)
+				if len(devices) == 0 {
+					delete(hub.clients, client.UserID)
+					lastDevice = true
+				}
 			}
 			hub.mutex.Unlock()
-			log.Printf("Client disconnected: UserID %d", client.UserID)
+			client.close(websocket.CloseNormalClosure, "")
+			log.Printf("Client disconnected: UserID %d, device %s", client.UserID, client.DeviceID)
 
-			// Broadcast offline status to friends
-			broadcastOnlineStatus(client.UserID, false)
+			// Broadcast offline status to friends once the last device is gone
+			if lastDevice {
+				localPresenceChanged(client.UserID, false)
+			}
 
 		case payload := <-hub.broadcast:
 			hub.mutex.RLock()
-			if client, ok := hub.clients[payload.UserID]; ok {
-				select {
-				case client.Send <- payload.Message:
-				default:
-					close(client.Send)
-					delete(hub.clients, payload.UserID)
+			for _, client := range hub.clients[payload.UserID] {
+				if payload.ExceptDevice != "" && client.DeviceID == payload.ExceptDevice {
+					continue
+				}
+				if payload.Seq > 0 {
+					client.enqueueEvent(payload.Seq, payload.Message)
+				} else {
+					client.enqueue(payload.Message)
 				}
 			}
 			hub.mutex.RUnlock()
@@ -89,47 +239,128 @@ func RunHub() {
 	}
 }
 
-// IsUserOnline checks if a user is currently connected
+// IsUserOnline checks if a user has at least one connected device on any instance
 func IsUserOnline(userID int64) bool {
+	return isLocallyOnline(userID) || onlineElsewhere(userID, "")
+}
+
+// isLocallyOnline checks if a user has a device connected to this instance
+func isLocallyOnline(userID int64) bool {
 	hub.mutex.RLock()
 	defer hub.mutex.RUnlock()
-	_, ok := hub.clients[userID]
-	return ok
+	return len(hub.clients[userID]) > 0
 }
 
-// BroadcastMessage sends a message to a specific user
+// BroadcastMessage sends a message to a specific user on every instance
 func BroadcastMessage(userID int64, msg models.WebSocketMessage) {
-	data, err := json.Marshal(msg)
+	BroadcastToUsers([]int64{userID}, 0, msg)
+}
+
+// BroadcastToUsers fans a message out to several users, skipping exceptUserID.
+// Unless the event is ephemeral it is logged first, so recipients who are
+// offline get it when they reconnect.
+func BroadcastToUsers(userIDs []int64, exceptUserID int64, msg models.WebSocketMessage) {
+	recipients := make([]int64, 0, len(userIDs))
+	for _, userID := range userIDs {
+		if userID != exceptUserID {
+			recipients = append(recipients, userID)
+		}
+	}
+	broadcastFromDevice(recipients, 0, "", msg)
+}
+
+// broadcastFromDevice fans a message out to several users like
+// BroadcastToUsers, except for senderID's device deviceID, which already has
+// it. The sender's other devices still get it, live and on replay.
+func broadcastFromDevice(recipients []int64, senderID int64, deviceID string, msg models.WebSocketMessage) {
+	if len(recipients) == 0 {
+		return
+	}
+	exceptDevice := func(userID int64) string {
+		if userID == senderID {
+			return deviceID
+		}
+		return ""
+	}
+
+	if ephemeralEvents[msg.Type] {
+		data, err := json.Marshal(msg)
+		if err != nil {
+			log.Printf("Error marshaling message: %v", err)
+			return
+		}
+		// Everyone but the sender shares one event
+		others := make([]int64, 0, len(recipients))
+		for _, userID := range recipients {
+			if exceptDevice(userID) != "" {
+				publish(broker.Event{
+					Type:         broker.EventDeliver,
+					UserIDs:      []int64{userID},
+					Data:         data,
+					ExceptDevice: deviceID,
+				})
+			} else {
+				others = append(others, userID)
+			}
+		}
+		if len(others) > 0 {
+			publish(broker.Event{
+				Type:    broker.EventDeliver,
+				UserIDs: others,
+				Data:    data,
+			})
+		}
+		return
+	}
+
+	payload, err := json.Marshal(msg.Payload)
 	if err != nil {
 		log.Printf("Error marshaling message: %v", err)
 		return
 	}
+	seqs := logEvent(recipients, msg.Type, payload, msg.Payload)
 
-	hub.broadcast <- BroadcastPayload{
-		UserID:  userID,
-		Message: data,
+	// Each recipient's frame carries their own log position
+	for _, userID := range recipients {
+		data, _ := json.Marshal(models.WebSocketMessage{
+			Type:    msg.Type,
+			Payload: json.RawMessage(payload),
+			Seq:     seqs[userID],
+		})
+		publish(broker.Event{
+			Type:         broker.EventDeliver,
+			UserIDs:      []int64{userID},
+			Seq:          seqs[userID],
+			Data:         data,
+			ExceptDevice: exceptDevice(userID),
+		})
 	}
 }
 
-// broadcastOnlineStatus notifies all connected clients about online status change
+// broadcastOnlineStatus tells a user's friends connected to this instance
+// that they came online or went offline. Every instance calls it when it sees
+// the cluster-wide status flip. Invisible users never appear to change.
 func broadcastOnlineStatus(userID int64, online bool) {
-	msg := models.WebSocketMessage{
-		Type: "online_status",
-		Payload: map[string]interface{}{
-			"user_id": userID,
-			"online":  online,
-		},
+	friendIDs, err := database.GetFriendIDs(userID)
+	if err != nil || len(friendIDs) == 0 {
+		return
+	}
+	stored, err := database.GetUserPresence([]int64{userID})
+	if err != nil {
+		log.Printf("Error loading presence: %v", err)
+		return
+	}
+	p := stored[userID]
+	if p.Presence == models.PresenceInvisible {
+		return
 	}
 
-	data, _ := json.Marshal(msg)
+	data, _ := json.Marshal(presenceEvent(userID, p.Presence.Visible(online), p.LastSeen))
 
 	hub.mutex.RLock()
-	for _, client := range hub.clients {
-		if client.UserID != userID {
-			select {
-			case client.Send <- data:
-			default:
-			}
+	for _, friendID := range friendIDs {
+		for _, client := range hub.clients[friendID] {
+			client.enqueue(data)
 		}
 	}
 	hub.mutex.RUnlock()
@@ -149,25 +380,69 @@ func HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Clients identify each device so a reconnect replaces its own old
+	// connection; without one every connection counts as a new device
+	deviceID := r.URL.Query().Get("device_id")
+	if deviceID == "" || len(deviceID) > maxDeviceIDLength {
+		deviceID = uuid.NewString()
+	}
+
+	// Reconnecting clients pass the last seq they saw to catch up on
+	// everything logged since
+	since, replay := int64(0), false
+	if s := r.URL.Query().Get("since"); s != "" {
+		if parsed, err := strconv.ParseInt(s, 10, 64); err == nil && parsed >= 0 {
+			since, replay = parsed, true
+		}
+	}
+	latest, floor, err := database.GetUserEventCursor(user.ID)
+	if err != nil {
+		log.Printf("Error loading event cursor: %v", err)
+	}
+
 	client := &Client{
-		Conn:   conn,
-		Send:   make(chan []byte, 256),
-		UserID: user.ID,
+		DeviceID:  deviceID,
+		Conn:      conn,
+		Send:      make(chan []byte, sendQueueSize),
+		UserID:    user.ID,
+		replaying: replay,
 	}
 
+	// Tell the device its ID so it can reuse it when reconnecting, and where
+	// its event log stands
+	hello, _ := json.Marshal(models.WebSocketMessage{
+		Type: "connected",
+		Payload: map[string]interface{}{
+			"device_id": deviceID,
+			"seq":       latest,
+		},
+	})
+	client.enqueue(hello)
+
 	hub.register <- client
 
 	// Start goroutines for reading and writing
 	go client.writePump()
+	if replay {
+		replayEvents(client, since, latest, floor)
+	}
 	go client.readPump()
 }
 
+// readPump handles frames from the client. A connection that sends nothing,
+// not even a pong, for pongWait is treated as dead.
 func (c *Client) readPump() {
 	defer func() {
 		hub.unregister <- c
 		c.Conn.Close()
 	}()
 
+	c.Conn.SetReadLimit(maxFrameSize)
+	c.Conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.Conn.SetPongHandler(func(string) error {
+		return c.Conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
 	for {
 		_, message, err := c.Conn.ReadMessage()
 		if err != nil {
@@ -177,17 +452,28 @@ func (c *Client) readPump() {
 			break
 		}
 
-		// Handle incoming messages (typing indicators, etc.)
-		var wsMsg models.WebSocketMessage
-		if err := json.Unmarshal(message, &wsMsg); err != nil {
+		// Any frame shows the connection is alive
+		c.Conn.SetReadDeadline(time.Now().Add(pongWait))
+
+		// Handle incoming messages (typing indicators, sends, etc.)
+		var frame struct {
+			Type    string          `json:"type"`
+			Payload json.RawMessage `json:"payload"`
+		}
+		if err := json.Unmarshal(message, &frame); err != nil {
 			continue
 		}
 
-		switch wsMsg.Type {
+		switch frame.Type {
 		case "typing":
 			// Forward typing indicator to recipient
-			if payload, ok := wsMsg.Payload.(map[string]interface{}); ok {
+			var payload map[string]interface{}
+			if json.Unmarshal(frame.Payload, &payload) == nil {
 				if recipientID, ok := payload["recipient_id"].(float64); ok {
+					// Typing never crosses a block
+					if blocked, err := database.IsBlocked(c.UserID, int64(recipientID)); err != nil || blocked {
+						continue
+					}
 					BroadcastMessage(int64(recipientID), models.WebSocketMessage{
 						Type: "typing",
 						Payload: map[string]interface{}{
@@ -197,16 +483,97 @@ func (c *Client) readPump() {
 					})
 				}
 			}
+
+		case "send":
+			// Handled inline so a device's messages are stored in the order sent
+			c.handleSend(frame.Payload)
+
+		case "delivered":
+			c.handleDelivered(frame.Payload)
 		}
 	}
 }
 
+// sendAck answers a send frame with the stored message's ID and timestamp,
+// or with the reason it was rejected
+type sendAck struct {
+	ClientID  string     `json:"client_id"`
+	ID        int64      `json:"id,omitempty"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	Duplicate bool       `json:"duplicate,omitempty"` // A retry of a message already stored
+	Error     *sendError `json:"error,omitempty"`
+}
+
+// handleSend stores a message sent over the socket, takes the same payload as
+// POST /api/messages plus a required client_id, and acks it to this device
+func (c *Client) handleSend(payload json.RawMessage) {
+	var req sendMessageRequest
+	var ack sendAck
+	if err := json.Unmarshal(payload, &req); err != nil {
+		ack.Error = newSendError(http.StatusBadRequest, sendErrInvalid, "Invalid send payload")
+	} else if req.ClientID == "" {
+		ack.Error = newSendError(http.StatusBadRequest, sendErrInvalid, "client_id is required")
+	} else if user, err := database.GetUserByID(c.UserID); err != nil || user.IsDisabled {
+		ack.Error = newSendError(http.StatusForbidden, sendErrForbidden, "Account unavailable")
+	} else if message, duplicate, sendErr := sendMessage(user, c.DeviceID, req); sendErr != nil {
+		ack.Error = sendErr
+	} else {
+		ack.ID = message.ID
+		ack.CreatedAt = &message.CreatedAt
+		ack.Duplicate = duplicate
+	}
+	ack.ClientID = req.ClientID
+
+	data, _ := json.Marshal(models.WebSocketMessage{Type: "ack", Payload: ack})
+	c.enqueue(data)
+}
+
+// handleDelivered records that this device got the listed messages and sends
+// delivery receipts to their senders
+func (c *Client) handleDelivered(payload json.RawMessage) {
+	var req struct {
+		MessageIDs []int64 `json:"message_ids"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil || len(req.MessageIDs) > maxReceiptIDs {
+		return
+	}
+	receipts, err := database.MarkMessagesDelivered(c.UserID, req.MessageIDs)
+	if err != nil {
+		log.Printf("Error marking messages delivered: %v", err)
+		return
+	}
+	sendDeliveredReceipts(receipts)
+}
+
+// writePump writes queued frames and pings. It owns all writes to the
+// connection and closes it when the queue is closed or a write fails.
 func (c *Client) writePump() {
-	defer c.Conn.Close()
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.Conn.Close()
+	}()
 
-	for message := range c.Send {
-		if err := c.Conn.WriteMessage(websocket.TextMessage, message); err != nil {
-			return
+	for {
+		select {
+		case message, ok := <-c.Send:
+			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				c.mu.Lock()
+				code, reason := c.closeCode, c.closeReason
+				c.mu.Unlock()
+				c.Conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason))
+				return
+			}
+			if err := c.Conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				return
+			}
+
+		case <-ticker.C:
+			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
 		}
 	}
 }
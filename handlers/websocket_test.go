@@ -0,0 +1,452 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"scuffedsnap/database"
+	"scuffedsnap/middleware"
+	"scuffedsnap/models"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "scuffedsnap-test")
+	if err != nil {
+		panic(err)
+	}
+	store, err := database.NewSQLiteStore(filepath.Join(dir, "test.db"))
+	if err != nil {
+		panic(err)
+	}
+	database.SetStore(store)
+	if _, err := database.MigrateUp(); err != nil {
+		panic(err)
+	}
+
+	// Shorten the heartbeat so dead connections are noticed within a test
+	writeWait = time.Second
+	pongWait = 300 * time.Millisecond
+	pingPeriod = 100 * time.Millisecond
+
+	go RunHub()
+
+	code := m.Run()
+	database.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// newTestServer serves HandleWebSocket, authenticating as the user ID in the
+// X-Test-User header
+func newTestServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strconv.ParseInt(r.Header.Get("X-Test-User"), 10, 64)
+		ctx := context.WithValue(r.Context(), middleware.UserContextKey, &models.User{ID: id})
+		HandleWebSocket(w, r.WithContext(ctx))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// dial connects as userID from deviceID
+func dial(t *testing.T, srv *httptest.Server, userID int64, deviceID string) *websocket.Conn {
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws?device_id=" + deviceID
+	header := http.Header{"X-Test-User": {strconv.FormatInt(userID, 10)}}
+	conn, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readUntilClosed reads frames, answering pings, until the connection
+// closes and returns the close error
+func readUntilClosed(conn *websocket.Conn) error {
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return err
+		}
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHeartbeatKeepsResponsiveConnection(t *testing.T) {
+	srv := newTestServer(t)
+	conn := dial(t, srv, 101, "phone")
+	go readUntilClosed(conn)
+
+	waitFor(t, "registration", func() bool { return isLocallyOnline(101) })
+	time.Sleep(4 * pongWait)
+	if !isLocallyOnline(101) {
+		t.Fatal("connection answering pings was dropped")
+	}
+}
+
+func TestHeartbeatDropsSilentConnection(t *testing.T) {
+	srv := newTestServer(t)
+	conn := dial(t, srv, 102, "phone")
+	// A half-open connection never answers pings
+	conn.SetPingHandler(func(string) error { return nil })
+	go readUntilClosed(conn)
+
+	waitFor(t, "registration", func() bool { return isLocallyOnline(102) })
+	waitFor(t, "silent connection to be dropped", func() bool { return !isLocallyOnline(102) })
+}
+
+func TestReconnectReplacesDevice(t *testing.T) {
+	srv := newTestServer(t)
+	first := dial(t, srv, 103, "laptop")
+	waitFor(t, "registration", func() bool { return isLocallyOnline(103) })
+
+	closed := make(chan error, 1)
+	go func() { closed <- readUntilClosed(first) }()
+	second := dial(t, srv, 103, "laptop")
+	go readUntilClosed(second)
+
+	select {
+	case err := <-closed:
+		if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+			t.Fatalf("replaced connection closed with %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("replaced connection was not closed")
+	}
+
+	time.Sleep(2 * pongWait)
+	if !isLocallyOnline(103) {
+		t.Fatal("replacement connection was dropped with the old one")
+	}
+}
+
+func TestSlowConsumerIsEvicted(t *testing.T) {
+	client := &Client{DeviceID: "tablet", Send: make(chan []byte, 2), UserID: 104}
+	hub.register <- client
+	waitFor(t, "registration", func() bool { return isLocallyOnline(104) })
+
+	for i := 0; i < 3; i++ {
+		BroadcastMessage(104, models.WebSocketMessage{Type: "test"})
+	}
+	waitFor(t, "eviction", client.isClosed)
+
+	client.mu.Lock()
+	code := client.closeCode
+	client.mu.Unlock()
+	if code != websocket.CloseTryAgainLater {
+		t.Fatalf("close code = %d, want %d", code, websocket.CloseTryAgainLater)
+	}
+
+	// Queued frames are still flushed before the close
+	var queued int
+	for range client.Send {
+		queued++
+	}
+	if queued != 2 {
+		t.Fatalf("flushed %d frames, want 2", queued)
+	}
+
+	// The reader unregisters the evicted client once its connection drops
+	hub.unregister <- client
+	waitFor(t, "unregistration", func() bool { return !isLocallyOnline(104) })
+}
+
+func TestBroadcastRacesWithDisconnects(t *testing.T) {
+	srv := newTestServer(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				BroadcastMessage(105, models.WebSocketMessage{Type: "test"})
+				time.Sleep(time.Millisecond)
+			}
+		}()
+	}
+
+	for i := 0; i < 20; i++ {
+		conn := dial(t, srv, 105, "device-"+strconv.Itoa(i%3))
+		go readUntilClosed(conn)
+		time.Sleep(5 * time.Millisecond)
+		conn.Close()
+	}
+	wg.Wait()
+
+	waitFor(t, "all devices to disconnect", func() bool { return !isLocallyOnline(105) })
+}
+
+// readFrame reads the next frame of the given type, skipping others
+func readFrame(t *testing.T, conn *websocket.Conn, frameType string) json.RawMessage {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for {
+		var frame struct {
+			Type    string          `json:"type"`
+			Payload json.RawMessage `json:"payload"`
+		}
+		if err := conn.ReadJSON(&frame); err != nil {
+			t.Fatalf("waiting for %s frame: %v", frameType, err)
+		}
+		if frame.Type == frameType {
+			return frame.Payload
+		}
+	}
+}
+
+func TestSendFrameAcksAndDropsRetries(t *testing.T) {
+	sender, err := database.CreateUserWithAuth("ws_sender", "ws_sender@example.com", "x", "email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiver, err := database.CreateUserWithAuth("ws_receiver", "ws_receiver@example.com", "x", "email")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := newTestServer(t)
+	conn := dial(t, srv, sender.ID, "phone")
+
+	send := func(clientID, content string) sendAck {
+		t.Helper()
+		err := conn.WriteJSON(map[string]interface{}{
+			"type": "send",
+			"payload": map[string]interface{}{
+				"client_id":   clientID,
+				"receiver_id": receiver.ID,
+				"content":     content,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ack sendAck
+		if err := json.Unmarshal(readFrame(t, conn, "ack"), &ack); err != nil {
+			t.Fatal(err)
+		}
+		return ack
+	}
+
+	first := send("retry-1", "hello")
+	if first.Error != nil || first.ID == 0 || first.CreatedAt == nil || first.Duplicate {
+		t.Fatalf("first ack = %+v", first)
+	}
+
+	retry := send("retry-1", "hello")
+	if retry.Error != nil || retry.ID != first.ID || !retry.Duplicate {
+		t.Fatalf("retry ack = %+v, want duplicate of %d", retry, first.ID)
+	}
+
+	messages, _, err := database.GetMessagesBetweenUsers(sender.ID, receiver.ID, models.Page{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 {
+		t.Fatalf("stored %d messages, want 1", len(messages))
+	}
+
+	rejected := send("retry-2", "")
+	if rejected.Error == nil || rejected.Error.Code != sendErrInvalid || rejected.ClientID != "retry-2" {
+		t.Fatalf("empty message ack = %+v", rejected)
+	}
+}
+
+func TestReconnectReplaysMissedEvents(t *testing.T) {
+	user, err := database.CreateUserWithAuth("ws_replay", "ws_replay@example.com", "x", "email")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Delivered while the user has no connection at all
+	for _, eventType := range []string{"friend_request", "message_deleted", "friend_accepted"} {
+		BroadcastMessage(user.ID, models.WebSocketMessage{Type: eventType, Payload: map[string]string{}})
+	}
+	BroadcastMessage(user.ID, models.WebSocketMessage{Type: "typing", Payload: map[string]string{}})
+
+	srv := newTestServer(t)
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws?since=1"
+	header := http.Header{"X-Test-User": {strconv.FormatInt(user.ID, 10)}}
+	conn, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var hello struct {
+		Seq int64 `json:"seq"`
+	}
+	json.Unmarshal(readFrame(t, conn, "connected"), &hello)
+	if hello.Seq != 3 {
+		t.Fatalf("connected seq = %d, want 3", hello.Seq)
+	}
+
+	// Events after the cursor come back in order; typing was never logged
+	for _, want := range []struct {
+		Type string
+		Seq  int64
+	}{{"message_deleted", 2}, {"friend_accepted", 3}} {
+		var frame models.WebSocketMessage
+		conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		if err := conn.ReadJSON(&frame); err != nil {
+			t.Fatal(err)
+		}
+		if frame.Type != want.Type || frame.Seq != want.Seq {
+			t.Fatalf("replayed %s #%d, want %s #%d", frame.Type, frame.Seq, want.Type, want.Seq)
+		}
+	}
+
+	// A cursor from the future can't be replayed
+	url = "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws?since=99"
+	stale, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stale.Close()
+	readFrame(t, stale, "resync_required")
+}
+
+func TestReceiptsNameChangedMessages(t *testing.T) {
+	sender, err := database.CreateUserWithAuth("ws_receipt_sender", "ws_receipt_sender@example.com", "x", "email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := database.CreateUserWithAuth("ws_receipt_reader", "ws_receipt_reader@example.com", "x", "email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int64
+	for _, content := range []string{"one", "two", "three"} {
+		msg, err := database.CreateMessage(sender.ID, reader.ID, content, "text", 0, "", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, msg.ID)
+	}
+
+	srv := newTestServer(t)
+	senderConn := dial(t, srv, sender.ID, "sender")
+	readerConn := dial(t, srv, reader.ID, "reader")
+	waitFor(t, "sender to register", func() bool { return isLocallyOnline(sender.ID) })
+
+	// Only messages addressed to the reader and not yet delivered count
+	readerConn.WriteJSON(map[string]interface{}{
+		"type":    "delivered",
+		"payload": map[string]interface{}{"message_ids": []int64{ids[0], ids[1], ids[0] + 1000}},
+	})
+	var delivered struct {
+		ReceiverID int64   `json:"receiver_id"`
+		MessageIDs []int64 `json:"message_ids"`
+	}
+	json.Unmarshal(readFrame(t, senderConn, "delivered"), &delivered)
+	if delivered.ReceiverID != reader.ID || len(delivered.MessageIDs) != 2 || delivered.MessageIDs[1] != ids[1] {
+		t.Fatalf("delivered receipt = %+v", delivered)
+	}
+
+	receipt, err := database.MarkMessagesAsRead(sender.ID, reader.ID, ids[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(receipt.MessageIDs) != 2 || receipt.MessageIDs[0] != ids[0] || receipt.MessageIDs[1] != ids[1] {
+		t.Fatalf("read up to %d changed %v", ids[1], receipt.MessageIDs)
+	}
+
+	// The rest is read, and delivered with it
+	receipt, err = database.MarkMessagesAsRead(sender.ID, reader.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last, err := database.GetMessageByID(ids[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(receipt.MessageIDs) != 1 || last.ReadAt == nil || last.DeliveredAt == nil {
+		t.Fatalf("read changed %v, last message = %+v", receipt.MessageIDs, last)
+	}
+}
+
+func TestSentMessageReachesSendersOtherDevices(t *testing.T) {
+	sender, err := database.CreateUserWithAuth("ws_multi_sender", "ws_multi_sender@example.com", "x", "email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiver, err := database.CreateUserWithAuth("ws_multi_receiver", "ws_multi_receiver@example.com", "x", "email")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := newTestServer(t)
+	phone := dial(t, srv, sender.ID, "phone")
+	laptop := dial(t, srv, sender.ID, "laptop")
+	inbox := dial(t, srv, receiver.ID, "phone")
+	waitFor(t, "devices to register", func() bool {
+		hub.mutex.RLock()
+		defer hub.mutex.RUnlock()
+		return len(hub.clients[sender.ID]) == 2 && len(hub.clients[receiver.ID]) == 1
+	})
+
+	phone.WriteJSON(map[string]interface{}{
+		"type": "send",
+		"payload": map[string]interface{}{
+			"client_id":   "multi-1",
+			"receiver_id": receiver.ID,
+			"content":     "hello",
+		},
+	})
+	var ack sendAck
+	json.Unmarshal(readFrame(t, phone, "ack"), &ack)
+	if ack.Error != nil || ack.ID == 0 {
+		t.Fatalf("ack = %+v", ack)
+	}
+
+	for name, conn := range map[string]*websocket.Conn{"laptop": laptop, "receiver": inbox} {
+		var msg models.MessageWithSender
+		json.Unmarshal(readFrame(t, conn, "message"), &msg)
+		if msg.ID != ack.ID {
+			t.Fatalf("%s got message %d, want %d", name, msg.ID, ack.ID)
+		}
+	}
+
+	// The hub queued the laptop's copy, so the phone's would be queued by now
+	phone.SetReadDeadline(time.Now().Add(3 * pongWait))
+	for {
+		var frame struct {
+			Type string `json:"type"`
+		}
+		if err := phone.ReadJSON(&frame); err != nil {
+			break
+		}
+		if frame.Type == "message" {
+			t.Fatal("sending device got its own message back")
+		}
+	}
+
+	// A reconnecting laptop replays it too
+	events, err := database.GetUserEvents(sender.ID, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Type != "message" {
+		t.Fatalf("sender's event log = %+v", events)
+	}
+}
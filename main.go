@@ -2,11 +2,18 @@ package main
 
 import (
 	"encoding/json"
+	"expvar"
 	"log"
 	"net/http"
 	"os"
 
 	"github.com/joho/godotenv"
+
+	"scuffedsnap/broker"
+	"scuffedsnap/database"
+	"scuffedsnap/handlers"
+	"scuffedsnap/media"
+	"scuffedsnap/retention"
 )
 
 func main() {
@@ -15,42 +22,120 @@ func main() {
 		log.Println("⚠️  No .env file found, using environment variables")
 	}
 
+	// On-demand schema migrations: scuffedsnap migrate [up|down [N]|status]
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(os.Args[2:])
+		return
+	}
+
+	// One-off retention run: scuffedsnap retention [--dry-run]
+	if len(os.Args) > 1 && os.Args[1] == "retention" {
+		runRetention(os.Args[2:])
+		return
+	}
+
 	// Get port from environment or use default
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
 	}
 
+	var handler http.Handler
+	if selfHosted() {
+		// Self-hosted mode: our own database, REST API and WebSocket hub
+		if err := database.Initialize(); err != nil {
+			log.Fatalf("Database initialization failed: %v", err)
+		}
+		blobs, err := media.NewStoreFromEnv()
+		if err != nil {
+			log.Fatalf("Media storage initialization failed: %v", err)
+		}
+		handlers.SetMediaStore(blobs)
+		events, err := broker.NewFromEnv()
+		if err != nil {
+			log.Fatalf("Broker initialization failed: %v", err)
+		}
+		handlers.SetBroker(events)
+		go handlers.RunHub()
+		go handlers.RunExpirySweeper()
+		policy := retention.PolicyFromEnv()
+		handlers.SetRetentionPolicy(policy)
+		go retention.RunWorker(policy, handlers.DiscardMessageMedia)
+		serveMetrics()
+		handler = newRouter()
+	} else {
+		handler = newSupabaseMux()
+	}
+
+	// Start server
+	log.Printf("🚀 ScuffedSnap server starting on http://localhost:%s\n", port)
+	log.Printf("📱 Open your browser and navigate to http://localhost:%s\n", port)
+	if selfHosted() {
+		log.Println("✅ Running in self-hosted mode")
+	} else {
+		log.Println("✅ Using Supabase for authentication and database")
+	}
+
+	if err := http.ListenAndServe(":"+port, handler); err != nil {
+		log.Fatalf("Server failed: %v", err)
+	}
+}
+
+// serveMetrics exposes expvar metrics (including retention runs) on
+// METRICS_ADDR, kept off the public listener
+func serveMetrics() {
+	addr := os.Getenv("METRICS_ADDR")
+	if addr == "" {
+		return
+	}
+	go func() {
+		log.Printf("📊 Metrics available on http://%s/debug/vars", addr)
+		if err := http.ListenAndServe(addr, expvar.Handler()); err != nil {
+			log.Printf("Metrics server failed: %v", err)
+		}
+	}()
+}
+
+// selfHosted reports whether SELF_HOSTED is set, enabling the Go API instead of Supabase
+func selfHosted() bool {
+	switch os.Getenv("SELF_HOSTED") {
+	case "1", "true", "TRUE", "yes":
+		return true
+	}
+	return false
+}
+
+// newSupabaseMux serves the static frontend, which talks to Supabase directly
+func newSupabaseMux() *http.ServeMux {
+	mux := http.NewServeMux()
+
 	// Static files
-	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("./static"))))
+	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("./static"))))
 
 	// API endpoint for config
-	http.HandleFunc("/api/config", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		config := map[string]string{
-			"supabaseUrl":    os.Getenv("SUPABASE_URL"),
-			"supabaseAnonKey": os.Getenv("SUPABASE_ANON_KEY"),
-		}
-		json.NewEncoder(w).Encode(config)
-	})
+	mux.HandleFunc("/api/config", serveConfig)
 
 	// HTML pages
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, "./static/index.html")
 	})
-	http.HandleFunc("/app", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/app", func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, "./static/app.html")
 	})
-	http.HandleFunc("/admin", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/admin", func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, "./static/admin.html")
 	})
 
-	// Start server
-	log.Printf("🚀 ScuffedSnap server starting on http://localhost:%s\n", port)
-	log.Printf("📱 Open your browser and navigate to http://localhost:%s\n", port)
-	log.Println("✅ Using Supabase for authentication and database")
+	return mux
+}
 
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
-		log.Fatalf("Server failed: %v", err)
+// serveConfig exposes the frontend configuration
+func serveConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	config := map[string]interface{}{
+		"supabaseUrl":     os.Getenv("SUPABASE_URL"),
+		"supabaseAnonKey": os.Getenv("SUPABASE_ANON_KEY"),
+		"selfHosted":      selfHosted(),
 	}
+	json.NewEncoder(w).Encode(config)
 }
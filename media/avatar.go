@@ -0,0 +1,118 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	_ "image/gif" // Register GIF decoding
+	_ "image/jpeg"
+	"image/png"
+)
+
+// AvatarSizes are the square edge lengths produced for every avatar, largest first
+var AvatarSizes = []int{256, 128, 64}
+
+// maxAvatarPixels bounds decoded images so a tiny file can't expand into a
+// huge bitmap
+const maxAvatarPixels = 40_000_000
+
+// ErrUnsupportedImage is returned for data that isn't a PNG, JPEG or GIF
+var ErrUnsupportedImage = errors.New("media: unsupported image")
+
+// ErrImageTooLarge is returned when an image's dimensions exceed the limit
+var ErrImageTooLarge = errors.New("media: image dimensions too large")
+
+// ProcessAvatar decodes a PNG, JPEG or GIF (first frame), center-crops it to a
+// square, turns a JPEG upright per its EXIF orientation and re-encodes it as
+// PNG at each of AvatarSizes. Only pixels are carried over, so EXIF and other
+// metadata never reach the output.
+func ProcessAvatar(data []byte) (map[int][]byte, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedImage
+	}
+	if format != "png" && format != "jpeg" && format != "gif" {
+		return nil, ErrUnsupportedImage
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxAvatarPixels {
+		return nil, ErrImageTooLarge
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedImage
+	}
+
+	// The centered square is the same whichever way up the image is, so
+	// orientation is applied after cropping, to fewer pixels
+	square := cropSquare(img)
+	if format == "jpeg" {
+		square = orientSquare(square, jpegOrientation(data))
+	}
+	out := make(map[int][]byte, len(AvatarSizes))
+	for _, size := range AvatarSizes {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, resizeBox(square, size)); err != nil {
+			return nil, err
+		}
+		out[size] = buf.Bytes()
+	}
+	return out, nil
+}
+
+// cropSquare copies the centered square of img into a premultiplied RGBA bitmap
+func cropSquare(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	side := b.Dx()
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+	origin := image.Pt(b.Min.X+(b.Dx()-side)/2, b.Min.Y+(b.Dy()-side)/2)
+
+	dst := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(dst, dst.Bounds(), img, origin, draw.Src)
+	return dst
+}
+
+// resizeBox scales a square bitmap to size×size by averaging the source
+// pixels covered by each destination pixel
+func resizeBox(src *image.RGBA, size int) *image.RGBA {
+	side := src.Bounds().Dx()
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+
+	for y := 0; y < size; y++ {
+		y0, y1 := span(y, size, side)
+		for x := 0; x < size; x++ {
+			x0, x1 := span(x, size, side)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r += uint64(p[0])
+					g += uint64(p[1])
+					b += uint64(p[2])
+					a += uint64(p[3])
+					n++
+				}
+			}
+
+			d := dst.Pix[y*dst.Stride+x*4:]
+			d[0], d[1], d[2], d[3] = uint8(r/n), uint8(g/n), uint8(b/n), uint8(a/n)
+		}
+	}
+	return dst
+}
+
+// span maps destination index i of n onto the half-open source range it
+// covers in a dimension of length side; it always covers at least one pixel
+func span(i, n, side int) (int, int) {
+	start := i * side / n
+	end := (i + 1) * side / n
+	if end <= start {
+		end = start + 1
+	}
+	return start, end
+}
@@ -0,0 +1,105 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// quadrantJPEG encodes a 64×64 JPEG whose quadrants are red, green (top) and
+// blue, white (bottom), tagged with an EXIF orientation in the given byte order
+func quadrantJPEG(t *testing.T, orientation int, order binary.ByteOrder) []byte {
+	t.Helper()
+	colors := [2][2]color.RGBA{
+		{{255, 0, 0, 255}, {0, 255, 0, 255}},
+		{{0, 0, 255, 255}, {255, 255, 255, 255}},
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			img.Set(x, y, colors[y/32][x/32])
+		}
+	}
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A TIFF header with one IFD entry: Orientation, SHORT, count 1
+	tiff := make([]byte, 26)
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], 1)
+	order.PutUint16(tiff[10:], exifOrientationTag)
+	order.PutUint16(tiff[12:], 3)
+	order.PutUint32(tiff[14:], 1)
+	order.PutUint16(tiff[18:], uint16(orientation))
+
+	app1 := append([]byte("Exif\x00\x00"), tiff...)
+	header := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(header[2:], uint16(len(app1)+2))
+
+	data := encoded.Bytes()
+	out := append([]byte{}, data[:2]...)
+	out = append(out, header...)
+	out = append(out, app1...)
+	return append(out, data[2:]...)
+}
+
+func TestProcessAvatarAppliesEXIFOrientation(t *testing.T) {
+	red, green := color.RGBA{255, 0, 0, 255}, color.RGBA{0, 255, 0, 255}
+	blue, white := color.RGBA{0, 0, 255, 255}, color.RGBA{255, 255, 255, 255}
+	// The upright top-left and top-right quadrants for each orientation
+	want := map[int][2]color.RGBA{
+		1: {red, green},
+		2: {green, red},
+		3: {white, blue},
+		4: {blue, white},
+		5: {red, blue},
+		6: {blue, red},
+		7: {white, green},
+		8: {green, white},
+	}
+
+	for orientation, corners := range want {
+		for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+			data := quadrantJPEG(t, orientation, order)
+			if got := jpegOrientation(data); got != orientation {
+				t.Fatalf("read orientation %d, want %d", got, orientation)
+			}
+
+			sizes, err := ProcessAvatar(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			img, err := png.Decode(bytes.NewReader(sizes[64]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, x := range []int{16, 48} {
+				if got := img.At(x, 16); !near(got, corners[i]) {
+					t.Errorf("orientation %d (%v): pixel (%d, 16) = %v, want %v", orientation, order, x, got, corners[i])
+				}
+			}
+		}
+	}
+}
+
+// near allows for JPEG's lossy compression
+func near(c color.Color, want color.RGBA) bool {
+	r, g, b, _ := c.RGBA()
+	diff := func(got uint32, want uint8) bool {
+		d := int(got>>8) - int(want)
+		return d > -40 && d < 40
+	}
+	return diff(r, want.R) && diff(g, want.G) && diff(b, want.B)
+}
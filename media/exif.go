@@ -0,0 +1,110 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+)
+
+// exifOrientationTag is the TIFF tag holding how a camera was held
+const exifOrientationTag = 0x0112
+
+// jpegOrientation reads the EXIF Orientation tag (1-8) from a JPEG's APP1
+// segment, returning 1, the upright default, when it is missing or malformed
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	for pos := 2; pos+4 <= len(data); {
+		if data[pos] != 0xFF {
+			return 1
+		}
+		marker := data[pos+1]
+		// Metadata only comes before the scan data
+		if marker == 0xDA || marker == 0xD9 {
+			return 1
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if length < 2 || pos+2+length > len(data) {
+			return 1
+		}
+		segment := data[pos+4 : pos+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return tiffOrientation(segment[6:])
+		}
+		pos += 2 + length
+	}
+	return 1
+}
+
+// tiffOrientation finds the Orientation tag in the first IFD of a TIFF header
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) != exifOrientationTag {
+			continue
+		}
+		// A SHORT value sits in the first two bytes of the value field
+		if o := int(order.Uint16(tiff[entry+8:])); o >= 1 && o <= 8 {
+			return o
+		}
+		return 1
+	}
+	return 1
+}
+
+// orientSquare rotates or flips a square bitmap so that an image stored with
+// the given EXIF orientation displays upright
+func orientSquare(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation <= 1 || orientation > 8 {
+		return src
+	}
+	n := src.Bounds().Dx()
+	dst := image.NewRGBA(image.Rect(0, 0, n, n))
+	last := n - 1
+
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			// Where the upright pixel (x, y) was stored
+			var sx, sy int
+			switch orientation {
+			case 2: // Mirrored
+				sx, sy = last-x, y
+			case 3: // Upside down
+				sx, sy = last-x, last-y
+			case 4: // Mirrored upside down
+				sx, sy = x, last-y
+			case 5: // Mirrored and turned left
+				sx, sy = y, x
+			case 6: // Turned left, so rotate clockwise
+				sx, sy = y, last-x
+			case 7: // Mirrored and turned right
+				sx, sy = last-y, last-x
+			case 8: // Turned right, so rotate counterclockwise
+				sx, sy = last-y, x
+			}
+			copy(dst.Pix[y*dst.Stride+x*4:y*dst.Stride+x*4+4], src.Pix[sy*src.Stride+sx*4:])
+		}
+	}
+	return dst
+}
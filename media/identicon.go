@@ -0,0 +1,49 @@
+package media
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// identiconGrid is the number of cells along each edge of an identicon
+const identiconGrid = 5
+
+// Identicon renders a deterministic, horizontally symmetric 5x5 pattern for
+// seed as a size×size PNG
+func Identicon(seed string, size int) []byte {
+	sum := sha256.Sum256([]byte(seed))
+
+	fg := color.NRGBA{R: sum[0], G: sum[1], B: sum[2], A: 255}
+	bg := color.NRGBA{R: 240, G: 240, B: 240, A: 255}
+
+	// Each of the 15 cells in the left three columns is on or off by one
+	// bit of the hash; the right two columns mirror the left
+	var cells [identiconGrid][identiconGrid]bool
+	bit := 0
+	for x := 0; x < (identiconGrid+1)/2; x++ {
+		for y := 0; y < identiconGrid; y++ {
+			on := sum[3+bit/8]>>(bit%8)&1 == 1
+			cells[y][x] = on
+			cells[y][identiconGrid-1-x] = on
+			bit++
+		}
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if cells[y*identiconGrid/size][x*identiconGrid/size] {
+				img.SetNRGBA(x, y, fg)
+			} else {
+				img.SetNRGBA(x, y, bg)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	return buf.Bytes()
+}
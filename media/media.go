@@ -0,0 +1,45 @@
+package media
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// DefaultMaxUploadBytes caps uploads when MEDIA_MAX_BYTES is unset
+const DefaultMaxUploadBytes = 10 << 20
+
+// allowedImageTypes are the MIME types accepted for image messages
+var allowedImageTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// DetectImageType sniffs the MIME type from the file's magic bytes and
+// reports whether it is an allowed image type. The client-supplied
+// Content-Type is never trusted.
+func DetectImageType(head []byte) (string, bool) {
+	mimeType := http.DetectContentType(head)
+	return mimeType, allowedImageTypes[mimeType]
+}
+
+// MaxUploadBytes returns the upload size limit from MEDIA_MAX_BYTES
+func MaxUploadBytes() int64 {
+	if v := os.Getenv("MEDIA_MAX_BYTES"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
+			return n
+		}
+	}
+	return DefaultMaxUploadBytes
+}
+
+// NewID returns an unguessable media ID
+func NewID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
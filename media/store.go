@@ -0,0 +1,108 @@
+package media
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNotFound is returned when a blob does not exist
+var ErrNotFound = errors.New("media: blob not found")
+
+// BlobStore persists uploaded files by key
+type BlobStore interface {
+	Put(key string, r io.Reader) error
+	Get(key string) (io.ReadCloser, error)
+	Delete(key string) error
+}
+
+// NewStoreFromEnv builds the blob store selected by MEDIA_STORAGE.
+// Only "filesystem" (the default) is supported today; files go to MEDIA_DIR
+// (default "./data/media").
+func NewStoreFromEnv() (BlobStore, error) {
+	switch kind := os.Getenv("MEDIA_STORAGE"); kind {
+	case "", "filesystem":
+		dir := os.Getenv("MEDIA_DIR")
+		if dir == "" {
+			dir = filepath.Join("data", "media")
+		}
+		return NewFilesystemStore(dir)
+	default:
+		return nil, fmt.Errorf("media: unknown MEDIA_STORAGE %q", kind)
+	}
+}
+
+// FilesystemStore keeps blobs as files under a root directory
+type FilesystemStore struct {
+	root string
+}
+
+// NewFilesystemStore creates root if needed and returns a store rooted there
+func NewFilesystemStore(root string) (*FilesystemStore, error) {
+	if err := os.MkdirAll(root, 0o750); err != nil {
+		return nil, err
+	}
+	return &FilesystemStore{root: root}, nil
+}
+
+// path shards keys into two-character subdirectories
+func (s *FilesystemStore) path(key string) (string, error) {
+	if len(key) < 3 || strings.ContainsAny(key, `/\.`) {
+		return "", fmt.Errorf("media: invalid key %q", key)
+	}
+	return filepath.Join(s.root, key[:2], key), nil
+}
+
+// Put writes the blob atomically via a temp file and rename
+func (s *FilesystemStore) Put(key string, r io.Reader) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Get opens the blob for reading
+func (s *FilesystemStore) Get(key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+// Delete removes the blob; deleting a missing blob is not an error
+func (s *FilesystemStore) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	"scuffedsnap/database"
+)
+
+// runMigrate handles `scuffedsnap migrate [up|down [N]|status]`
+func runMigrate(args []string) {
+	if err := database.Open(); err != nil {
+		log.Fatalf("Database connection failed: %v", err)
+	}
+	defer database.Close()
+
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	switch command {
+	case "up":
+		applied, err := database.MigrateUp()
+		if err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		log.Printf("✅ Applied %d migration(s)", applied)
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				log.Fatalf("Invalid step count %q", args[1])
+			}
+			steps = n
+		}
+		rolledBack, err := database.MigrateDown(steps)
+		if err != nil {
+			log.Fatalf("Rollback failed: %v", err)
+		}
+		log.Printf("✅ Rolled back %d migration(s)", rolledBack)
+
+	case "status":
+		statuses, err := database.GetMigrationStatus()
+		if err != nil {
+			log.Fatalf("Failed to read migration status: %v", err)
+		}
+		for _, s := range statuses {
+			state := "pending"
+			if s.Applied {
+				state = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%-30s %s\n", s.Version, s.Name, state)
+		}
+
+	default:
+		log.Fatalf("Unknown migrate command %q (expected up, down [N] or status)", command)
+	}
+}
@@ -0,0 +1,90 @@
+package models
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cursor is a position in a list ordered by time and then ID. Clients get it
+// as an opaque string and pass it back to fetch the next page.
+type Cursor struct {
+	Time time.Time
+	ID   int64
+}
+
+// ErrInvalidCursor is returned for cursors that String didn't produce
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// String encodes the cursor for API responses
+func (c Cursor) String() string {
+	raw := c.Time.UTC().Format(time.RFC3339Nano) + "|" + strconv.FormatInt(c.ID, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// ParseCursor decodes a cursor produced by String
+func ParseCursor(s string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	at, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+	t, err := time.Parse(time.RFC3339Nano, at)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &Cursor{Time: t, ID: n}, nil
+}
+
+// Page selects up to Limit items older than Before or newer than After. With
+// neither set it selects the newest items.
+type Page struct {
+	Before *Cursor
+	After  *Cursor
+	Limit  int
+}
+
+// UserSearchCursor is a position in user search results, which are ordered by
+// match tier, then mutual friends, then similarity score, then ID
+type UserSearchCursor struct {
+	Tier          int
+	MutualFriends int
+	Score         int
+	ID            int64
+}
+
+// String encodes the cursor for API responses
+func (c UserSearchCursor) String() string {
+	raw := strings.Join([]string{
+		strconv.Itoa(c.Tier), strconv.Itoa(c.MutualFriends), strconv.Itoa(c.Score), strconv.FormatInt(c.ID, 10),
+	}, "|")
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// ParseUserSearchCursor decodes a cursor produced by UserSearchCursor.String
+func ParseUserSearchCursor(s string) (*UserSearchCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	parts := strings.Split(string(raw), "|")
+	if len(parts) != 4 {
+		return nil, ErrInvalidCursor
+	}
+	var n [4]int64
+	for i, part := range parts {
+		if n[i], err = strconv.ParseInt(part, 10, 64); err != nil {
+			return nil, ErrInvalidCursor
+		}
+	}
+	return &UserSearchCursor{Tier: int(n[0]), MutualFriends: int(n[1]), Score: int(n[2]), ID: n[3]}, nil
+}
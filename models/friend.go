@@ -18,6 +18,7 @@ type Friend struct {
 	FriendID  int64        `json:"friend_id"`
 	Status    FriendStatus `json:"status"`
 	CreatedAt time.Time    `json:"created_at"`
+	ExpiresAt *time.Time   `json:"expires_at,omitempty"` // Pending requests lapse at this time
 }
 
 // FriendWithUser includes the friend's user info
@@ -32,4 +33,14 @@ type FriendRequest struct {
 	From      UserResponse `json:"from"`
 	Status    FriendStatus `json:"status"`
 	CreatedAt time.Time    `json:"created_at"`
+	ExpiresAt *time.Time   `json:"expires_at,omitempty"`
+}
+
+// OutgoingFriendRequest represents a friend request the user has sent
+type OutgoingFriendRequest struct {
+	ID        int64        `json:"id"`
+	To        UserResponse `json:"to"`
+	Status    FriendStatus `json:"status"`
+	CreatedAt time.Time    `json:"created_at"`
+	ExpiresAt *time.Time   `json:"expires_at,omitempty"`
 }
@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// GroupRole is a member's role within a group conversation
+type GroupRole string
+
+const (
+	GroupRoleOwner  GroupRole = "owner"
+	GroupRoleAdmin  GroupRole = "admin"
+	GroupRoleMember GroupRole = "member"
+)
+
+// CanManage reports whether the role may invite, kick and rename
+func (r GroupRole) CanManage() bool {
+	return r == GroupRoleOwner || r == GroupRoleAdmin
+}
+
+// Group represents a multi-user conversation
+type Group struct {
+	ID        int64         `json:"id"`
+	Name      string        `json:"name"`
+	Avatar    string        `json:"avatar"`
+	CreatedBy int64         `json:"created_by"`
+	CreatedAt time.Time     `json:"created_at"`
+	Members   []GroupMember `json:"members,omitempty"`
+}
+
+// GroupMember is a user's membership in a group
+type GroupMember struct {
+	User              UserResponse `json:"user"`
+	Role              GroupRole    `json:"role"`
+	LastReadMessageID int64        `json:"-"`
+	JoinedAt          time.Time    `json:"joined_at"`
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// Media is an uploaded file referenced by image messages
+type Media struct {
+	ID        string    `json:"id"`
+	OwnerID   int64     `json:"owner_id"`
+	MimeType  string    `json:"mime_type"`
+	Size      int64     `json:"size"`
+	MessageID int64     `json:"message_id,omitempty"` // Set once attached to a message
+	CreatedAt time.Time `json:"created_at"`
+	URL       string    `json:"url"`
+}
@@ -4,32 +4,142 @@ import "time"
 
 // Message represents a chat message between users
 type Message struct {
-	ID         int64      `json:"id"`
-	SenderID   int64      `json:"sender_id"`
-	ReceiverID int64      `json:"receiver_id"`
-	Content    string     `json:"content"`
-	Type       string     `json:"type"` // "text", "image", "snap"
-	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
-	ReadAt     *time.Time `json:"read_at,omitempty"`
-	CreatedAt  time.Time  `json:"created_at"`
+	ID               int64           `json:"id"`
+	SenderID         int64           `json:"sender_id"`
+	ReceiverID       int64           `json:"receiver_id,omitempty"`     // Set for direct messages
+	ConversationID   int64           `json:"conversation_id,omitempty"` // Set for group messages
+	Content          string          `json:"content"`
+	Type             string          `json:"type"` // "text", "image", "snap"
+	ExpiresAt        *time.Time      `json:"expires_at,omitempty"`
+	DeliveredAt      *time.Time      `json:"delivered_at,omitempty"` // When a receiver's device first got the message
+	ReadAt           *time.Time      `json:"read_at,omitempty"`      // Hidden from the sender when the receiver turns read receipts off
+	CreatedAt        time.Time       `json:"created_at"`
+	Edited           bool            `json:"edited"`
+	UpdatedAt        *time.Time      `json:"updated_at,omitempty"`        // Last edit time
+	OpenedAt         *time.Time      `json:"opened_at,omitempty"`         // When a snap was opened
+	ViewSeconds      int             `json:"view_seconds,omitempty"`      // How long an opened snap stays viewable
+	DisappearSeconds int             `json:"disappear_seconds,omitempty"` // Expires this long after being read
+	ClientID         string          `json:"client_id,omitempty"`         // Sender-generated ID used to drop retried sends
+	ReplyToID        int64           `json:"reply_to_id,omitempty"`       // The message this one quotes
+	ReplyTo          *MessagePreview `json:"reply_to,omitempty"`
+}
+
+// MessagePreview is the compact quote of a replied-to message. Once that
+// message expires or is deleted only its ID remains, with Deleted set.
+type MessagePreview struct {
+	ID             int64  `json:"id"`
+	SenderID       int64  `json:"sender_id,omitempty"`
+	SenderUsername string `json:"sender_username,omitempty"`
+	Type           string `json:"type,omitempty"`
+	Content        string `json:"content,omitempty"` // Text messages only, shortened
+	Deleted        bool   `json:"deleted,omitempty"`
 }
 
 // MessageWithSender includes sender info for display
 type MessageWithSender struct {
 	Message
-	SenderUsername string `json:"sender_username"`
-	SenderAvatar   string `json:"sender_avatar"`
+	SenderUsername string            `json:"sender_username"`
+	SenderAvatar   string            `json:"sender_avatar"`
+	Reactions      []ReactionSummary `json:"reactions,omitempty"`
 }
 
-// Conversation represents a chat thread with another user
+// Conversation types
+const (
+	ConversationDirect = "direct"
+	ConversationGroup  = "group"
+)
+
+// Conversation represents a chat thread with another user or a group
 type Conversation struct {
-	User        UserResponse `json:"user"`
-	LastMessage *Message     `json:"last_message,omitempty"`
-	UnreadCount int          `json:"unread_count"`
+	Type        string        `json:"type"` // "direct" or "group"
+	User        *UserResponse `json:"user,omitempty"`
+	Group       *Group        `json:"group,omitempty"`
+	LastMessage *Message      `json:"last_message,omitempty"`
+	UnreadCount int           `json:"unread_count"`
+}
+
+// LastActivity returns when the conversation last changed, for inbox ordering
+func (c *Conversation) LastActivity() time.Time {
+	if c.LastMessage != nil {
+		return c.LastMessage.CreatedAt
+	}
+	if c.Group != nil {
+		return c.Group.CreatedAt
+	}
+	return time.Time{}
+}
+
+// Cursor is the conversation's position in the inbox: its last activity, then
+// its last message ID, or minus the group ID for a group with no messages
+func (c *Conversation) Cursor() Cursor {
+	var id int64
+	if c.LastMessage != nil {
+		id = c.LastMessage.ID
+	} else if c.Group != nil {
+		id = -c.Group.ID
+	}
+	return Cursor{Time: c.LastActivity(), ID: id}
 }
 
 // WebSocketMessage is the format for real-time messages
 type WebSocketMessage struct {
 	Type    string      `json:"type"` // "message", "typing", "read", "online"
 	Payload interface{} `json:"payload"`
+	Seq     int64       `json:"seq,omitempty"` // Position in the recipient's event log; unset for ephemeral events
+}
+
+// Receipt lists the messages from one sender that a receiver's device got or
+// the receiver read, at the same moment
+type Receipt struct {
+	SenderID   int64     `json:"sender_id"`
+	ReceiverID int64     `json:"receiver_id"`
+	MessageIDs []int64   `json:"message_ids"`
+	At         time.Time `json:"at"`
+}
+
+// UserEvent is a logged WebSocket event, kept so reconnecting clients can
+// catch up on what they missed
+type UserEvent struct {
+	Seq       int64
+	Type      string
+	Payload   string // JSON
+	CreatedAt time.Time
+}
+
+// MessageSearch is a full-text search over the caller's conversations, with
+// optional filters
+type MessageSearch struct {
+	Query          string
+	WithUserID     int64 // Only the direct conversation with this user
+	ConversationID int64 // Only this group
+	SenderID       int64
+	From           *time.Time // Sent at or after
+	To             *time.Time // Sent before
+	Limit          int
+	Offset         int
+}
+
+// MessageSearchResult is a message matching a search, with an excerpt whose
+// matches are wrapped in \x01 and \x02 until the handler renders it as HTML
+type MessageSearchResult struct {
+	MessageWithSender
+	Highlight string  `json:"highlight"`
+	Rank      float64 `json:"rank"`
+}
+
+// ReactionSummary aggregates one emoji's reactions to a message
+type ReactionSummary struct {
+	Emoji   string  `json:"emoji"`
+	Count   int     `json:"count"`
+	UserIDs []int64 `json:"user_ids"`
+}
+
+// ReactionEvent is the payload of the reaction WebSocket event: what changed,
+// and the message's reactions after the change
+type ReactionEvent struct {
+	MessageID int64             `json:"message_id"`
+	UserID    int64             `json:"user_id"`
+	Emoji     string            `json:"emoji"`
+	Added     bool              `json:"added"`
+	Reactions []ReactionSummary `json:"reactions"`
 }
@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// Presence is the state a user shows to their friends
+type Presence string
+
+const (
+	PresenceOnline    Presence = "online"
+	PresenceAway      Presence = "away"
+	PresenceInvisible Presence = "invisible" // Connected but shown as offline
+	PresenceOffline   Presence = "offline"   // Shown only; users can't choose it
+)
+
+// Selectable reports whether a user may choose the state
+func (p Presence) Selectable() bool {
+	return p == PresenceOnline || p == PresenceAway || p == PresenceInvisible
+}
+
+// Visible returns the state friends see for a user who chose p and is or
+// isn't connected
+func (p Presence) Visible(connected bool) Presence {
+	if !connected || p == PresenceInvisible {
+		return PresenceOffline
+	}
+	if p == "" {
+		return PresenceOnline
+	}
+	return p
+}
+
+// UserPresence is a user's stored presence
+type UserPresence struct {
+	UserID   int64
+	Presence Presence
+	LastSeen *time.Time
+}
@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// RetentionOverride replaces the global retention policy for one conversation.
+// Groups set ConversationID; direct chats set the UserLow/UserHigh pair.
+type RetentionOverride struct {
+	ConversationID int64     `json:"conversation_id,omitempty"`
+	UserLow        int64     `json:"user_low,omitempty"`
+	UserHigh       int64     `json:"user_high,omitempty"`
+	RetentionDays  int       `json:"retention_days"` // 0 keeps messages forever
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// GroupRetention builds an override for a group conversation
+func GroupRetention(groupID int64, days int) *RetentionOverride {
+	return &RetentionOverride{ConversationID: groupID, RetentionDays: days}
+}
+
+// DirectRetention builds an override for the direct chat between two users
+func DirectRetention(userID1, userID2 int64, days int) *RetentionOverride {
+	if userID1 > userID2 {
+		userID1, userID2 = userID2, userID1
+	}
+	return &RetentionOverride{UserLow: userID1, UserHigh: userID2, RetentionDays: days}
+}
@@ -1,29 +1,63 @@
 package models
 
-import "time"
+import (
+	"strconv"
+	"time"
+)
 
 // User represents a user in the system
 type User struct {
-	ID         int64     `json:"id"`
-	Username   string    `json:"username"`
-	Email      string    `json:"email"`
-	Password   string    `json:"-"` // Never send password in JSON
-	Avatar     string    `json:"avatar"`
-	AuthMethod string    `json:"auth_method"`
-	IsDisabled bool      `json:"is_disabled"`
-	CreatedAt  time.Time `json:"created_at"`
+	ID         int64      `json:"id"`
+	Username   string     `json:"username"`
+	Email      string     `json:"email"`
+	Password   string     `json:"-"` // Never send password in JSON
+	Avatar     string     `json:"avatar"`
+	AuthMethod string     `json:"auth_method"`
+	IsDisabled bool       `json:"is_disabled"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Presence   Presence   `json:"presence"`
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty"`
+	// ReadReceipts controls whether senders are told when this user reads their messages
+	ReadReceipts bool `json:"read_receipts"`
 }
 
 // UserResponse is the safe version of User for API responses
 type UserResponse struct {
-	ID         int64     `json:"id"`
-	Username   string    `json:"username"`
-	Email      string    `json:"email"`
-	Avatar     string    `json:"avatar"`
-	AuthMethod string    `json:"auth_method"`
-	IsDisabled bool      `json:"is_disabled"`
-	CreatedAt  time.Time `json:"created_at"`
-	Online     bool      `json:"online"`
+	ID         int64      `json:"id"`
+	Username   string     `json:"username"`
+	Email      string     `json:"email,omitempty"` // Left out of search results
+	Avatar     string     `json:"avatar"`
+	AuthMethod string     `json:"auth_method,omitempty"` // Left out of search results
+	IsDisabled bool       `json:"is_disabled"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Online     bool       `json:"online"`
+	Status     Presence   `json:"status,omitempty"`    // Set only for friends and yourself
+	LastSeen   *time.Time `json:"last_seen,omitempty"` // Set only for friends
+	// ReadReceipts is set only on your own profile
+	ReadReceipts *bool `json:"read_receipts,omitempty"`
+}
+
+// UserSearchResult is a user found by SearchUsers, with how many accepted
+// friends they share with the searcher
+type UserSearchResult struct {
+	UserResponse
+	MutualFriends int `json:"mutual_friends"`
+	// Position places the result in the search order for pagination
+	Position UserSearchCursor `json:"-"`
+}
+
+// IdenticonURL is the generated avatar shown for users who haven't uploaded one
+func IdenticonURL(userID int64) string {
+	return "/api/identicons/" + strconv.FormatInt(userID, 10)
+}
+
+// AvatarURL is the avatar to show for a user: their upload, or else their
+// identicon. Every response carrying a user's avatar goes through it.
+func AvatarURL(userID int64, avatar string) string {
+	if avatar == "" {
+		return IdenticonURL(userID)
+	}
+	return avatar
 }
 
 // ToResponse converts User to UserResponse
@@ -32,7 +66,7 @@ func (u *User) ToResponse() UserResponse {
 		ID:         u.ID,
 		Username:   u.Username,
 		Email:      u.Email,
-		Avatar:     u.Avatar,
+		Avatar:     AvatarURL(u.ID, u.Avatar),
 		AuthMethod: u.AuthMethod,
 		IsDisabled: u.IsDisabled,
 		CreatedAt:  u.CreatedAt,
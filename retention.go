@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+
+	"scuffedsnap/database"
+	"scuffedsnap/handlers"
+	"scuffedsnap/media"
+	"scuffedsnap/retention"
+)
+
+// runRetention handles `scuffedsnap retention [--dry-run]`, applying the
+// retention policy once and printing the report
+func runRetention(args []string) {
+	if err := database.Open(); err != nil {
+		log.Fatalf("Database connection failed: %v", err)
+	}
+	defer database.Close()
+
+	policy := retention.PolicyFromEnv()
+	for _, arg := range args {
+		switch arg {
+		case "--dry-run", "-n":
+			policy.DryRun = true
+		default:
+			log.Fatalf("Unknown retention flag %q (expected --dry-run)", arg)
+		}
+	}
+
+	blobs, err := media.NewStoreFromEnv()
+	if err != nil {
+		log.Fatalf("Media storage initialization failed: %v", err)
+	}
+	handlers.SetMediaStore(blobs)
+
+	report, err := retention.Run(policy, handlers.DiscardMessageMedia)
+	if err != nil {
+		log.Fatalf("Retention run failed: %v", err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(report)
+}
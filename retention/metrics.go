@@ -0,0 +1,44 @@
+package retention
+
+import (
+	"expvar"
+	"sync"
+)
+
+// Metrics are published through expvar under "retention"
+var (
+	metrics = expvar.NewMap("retention")
+
+	lastMu     sync.Mutex
+	lastReport *Report
+)
+
+func init() {
+	metrics.Set("last_run", expvar.Func(func() interface{} {
+		return LastReport()
+	}))
+}
+
+// record adds a worker run to the metrics
+func record(report *Report, err error) {
+	metrics.Add("runs", 1)
+	if err != nil {
+		metrics.Add("failures", 1)
+	}
+	if report.DryRun {
+		metrics.Add("rows_matched_dry_run", report.Rows)
+	} else {
+		metrics.Add("rows_deleted", report.Rows)
+	}
+
+	lastMu.Lock()
+	lastReport = report
+	lastMu.Unlock()
+}
+
+// LastReport returns the most recent worker run, or nil before the first one
+func LastReport() *Report {
+	lastMu.Lock()
+	defer lastMu.Unlock()
+	return lastReport
+}
@@ -0,0 +1,175 @@
+// Package retention deletes old messages according to a global policy and
+// per-conversation overrides
+package retention
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"scuffedsnap/database"
+	"scuffedsnap/models"
+)
+
+// batchPause gives other writers a turn between delete batches
+const batchPause = 50 * time.Millisecond
+
+// Policy is the global retention configuration
+type Policy struct {
+	Days      int           // Delete messages older than this; 0 disables the global policy
+	Interval  time.Duration // Time between worker runs
+	BatchSize int           // Messages removed per delete transaction
+	DryRun    bool          // Count what would be removed without deleting anything
+}
+
+// PolicyFromEnv reads RETENTION_DAYS (default 0, disabled), RETENTION_INTERVAL
+// (default 1h), RETENTION_BATCH_SIZE (default 500) and RETENTION_DRY_RUN
+func PolicyFromEnv() Policy {
+	p := Policy{Interval: time.Hour, BatchSize: 500}
+
+	if n, err := strconv.Atoi(os.Getenv("RETENTION_DAYS")); err == nil && n > 0 {
+		p.Days = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("RETENTION_INTERVAL")); err == nil && d > 0 {
+		p.Interval = d
+	}
+	if n, err := strconv.Atoi(os.Getenv("RETENTION_BATCH_SIZE")); err == nil && n > 0 {
+		p.BatchSize = n
+	}
+	switch os.Getenv("RETENTION_DRY_RUN") {
+	case "1", "true", "TRUE", "yes":
+		p.DryRun = true
+	}
+	return p
+}
+
+// Cap limits an override to the global policy: while one is in force, an
+// override can shorten retention but not keep messages longer or forever
+func (p Policy) Cap(days int) int {
+	if p.Days > 0 && (days == 0 || days > p.Days) {
+		return p.Days
+	}
+	return days
+}
+
+// ScopeReport covers one policy applied during a run
+type ScopeReport struct {
+	Scope         string    `json:"scope"` // "global", "group:<id>" or "direct:<low>-<high>"
+	RetentionDays int       `json:"retention_days"`
+	Cutoff        time.Time `json:"cutoff"`
+	Rows          int64     `json:"rows"` // Removed, or that would be removed in a dry run
+	Batches       int       `json:"batches"`
+}
+
+// Report summarizes one retention run
+type Report struct {
+	StartedAt time.Time     `json:"started_at"`
+	Duration  time.Duration `json:"duration_ns"`
+	DryRun    bool          `json:"dry_run"`
+	Rows      int64         `json:"rows"`
+	Scopes    []ScopeReport `json:"scopes"`
+}
+
+// Run applies the global policy and every override, capped to it, once.
+// onDelete, if set, receives each batch of removed messages so their media
+// can be cleaned up.
+func Run(p Policy, onDelete func([]models.Message)) (*Report, error) {
+	report := &Report{StartedAt: time.Now().UTC(), DryRun: p.DryRun}
+	defer func() { report.Duration = time.Since(report.StartedAt) }()
+
+	overrides, err := database.GetRetentionOverrides()
+	if err != nil {
+		return report, err
+	}
+
+	if p.Days > 0 {
+		if err := runScope(report, p, nil, p.Days, onDelete); err != nil {
+			return report, err
+		}
+	}
+	for i := range overrides {
+		o := &overrides[i]
+		days := p.Cap(o.RetentionDays)
+		if days == 0 {
+			continue
+		}
+		if err := runScope(report, p, o, days, onDelete); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// runScope counts or deletes one policy's messages in batches
+func runScope(report *Report, p Policy, o *models.RetentionOverride, days int, onDelete func([]models.Message)) error {
+	scope := ScopeReport{
+		Scope:         scopeName(o),
+		RetentionDays: days,
+		Cutoff:        time.Now().UTC().AddDate(0, 0, -days),
+	}
+
+	if p.DryRun {
+		count, err := database.CountPurgeableMessages(o, scope.Cutoff)
+		if err != nil {
+			return err
+		}
+		scope.Rows = count
+	} else {
+		for {
+			deleted, err := database.PurgeMessages(o, scope.Cutoff, p.BatchSize)
+			if err != nil {
+				return err
+			}
+			if len(deleted) > 0 {
+				scope.Rows += int64(len(deleted))
+				scope.Batches++
+				if onDelete != nil {
+					onDelete(deleted)
+				}
+			}
+			if len(deleted) < p.BatchSize {
+				break
+			}
+			time.Sleep(batchPause)
+		}
+	}
+
+	report.Rows += scope.Rows
+	report.Scopes = append(report.Scopes, scope)
+	return nil
+}
+
+// scopeName labels a policy in reports
+func scopeName(o *models.RetentionOverride) string {
+	switch {
+	case o == nil:
+		return "global"
+	case o.ConversationID != 0:
+		return fmt.Sprintf("group:%d", o.ConversationID)
+	default:
+		return fmt.Sprintf("direct:%d-%d", o.UserLow, o.UserHigh)
+	}
+}
+
+// RunWorker applies the policy every p.Interval, logging each run and
+// recording it in the exported metrics
+func RunWorker(p Policy, onDelete func([]models.Message)) {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		report, err := Run(p, onDelete)
+		record(report, err)
+		if err != nil {
+			log.Printf("Retention run failed: %v", err)
+			continue
+		}
+		if report.DryRun {
+			log.Printf("Retention dry run: %d message(s) would be removed in %v", report.Rows, report.Duration)
+		} else if report.Rows > 0 {
+			log.Printf("Retention removed %d message(s) in %v", report.Rows, report.Duration)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package retention
+
+import "testing"
+
+func TestCapKeepsOverridesWithinGlobalPolicy(t *testing.T) {
+	global := Policy{Days: 30}
+	for _, tc := range []struct{ days, want int }{
+		{0, 30},   // Keep forever
+		{365, 30}, // Longer than the policy
+		{7, 7},    // Shorter is allowed
+		{30, 30},
+	} {
+		if got := global.Cap(tc.days); got != tc.want {
+			t.Errorf("Cap(%d) = %d, want %d", tc.days, got, tc.want)
+		}
+	}
+
+	// Without a global policy overrides stand as set
+	if got := (Policy{}).Cap(0); got != 0 {
+		t.Errorf("Cap(0) with no policy = %d, want 0", got)
+	}
+}
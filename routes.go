@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"scuffedsnap/handlers"
+	"scuffedsnap/middleware"
+)
+
+// newRouter builds the self-hosted router with the full REST API under /api
+func newRouter() *mux.Router {
+	r := mux.NewRouter()
+
+	api := r.PathPrefix("/api").Subrouter()
+	api.HandleFunc("/config", serveConfig).Methods("GET")
+
+	// Public auth routes
+	api.HandleFunc("/auth/signup", handlers.Signup).Methods("POST")
+	api.HandleFunc("/auth/login", handlers.Login).Methods("POST")
+	api.HandleFunc("/auth/logout", handlers.Logout).Methods("POST")
+	api.Handle("/auth/session", middleware.OptionalAuth(http.HandlerFunc(handlers.GetSession))).Methods("GET")
+
+	// Public avatar images
+	api.HandleFunc("/avatars/{id}", handlers.GetAvatar).Methods("GET")
+	api.HandleFunc("/identicons/{id}", handlers.GetIdenticon).Methods("GET")
+
+	// Routes that require a valid session
+	protected := api.NewRoute().Subrouter()
+	protected.Use(middleware.Auth)
+
+	protected.HandleFunc("/me", handlers.Me).Methods("GET")
+	protected.HandleFunc("/me/avatar", handlers.UploadAvatar).Methods("POST")
+	protected.HandleFunc("/me/presence", handlers.SetPresence).Methods("PUT")
+	protected.HandleFunc("/me/privacy", handlers.SetPrivacy).Methods("PUT")
+
+	protected.HandleFunc("/conversations", handlers.GetConversations).Methods("GET")
+	protected.HandleFunc("/messages", handlers.SendMessage).Methods("POST")
+	protected.HandleFunc("/messages/{userId}", handlers.GetMessages).Methods("GET")
+	protected.HandleFunc("/messages/{userId}/read", handlers.MarkAsRead).Methods("POST")
+	protected.HandleFunc("/messages/{id}", handlers.EditMessage).Methods("PATCH")
+	protected.HandleFunc("/messages/{id}", handlers.DeleteMessage).Methods("DELETE")
+	protected.HandleFunc("/messages/{id}/open", handlers.OpenSnap).Methods("POST")
+	protected.HandleFunc("/messages/{id}/thread", handlers.GetThread).Methods("GET")
+	protected.HandleFunc("/messages/{id}/reactions/{emoji}", handlers.SetReaction).Methods("PUT", "DELETE")
+	protected.HandleFunc("/messages/{userId}/retention", handlers.SetDirectRetention).Methods("PUT", "DELETE")
+
+	protected.HandleFunc("/groups", handlers.CreateGroup).Methods("POST")
+	protected.HandleFunc("/groups/{id}", handlers.GetGroup).Methods("GET")
+	protected.HandleFunc("/groups/{id}", handlers.UpdateGroup).Methods("PATCH")
+	protected.HandleFunc("/groups/{id}/messages", handlers.GetGroupMessages).Methods("GET")
+	protected.HandleFunc("/groups/{id}/read", handlers.MarkGroupAsRead).Methods("POST")
+	protected.HandleFunc("/groups/{id}/leave", handlers.LeaveGroup).Methods("POST")
+	protected.HandleFunc("/groups/{id}/retention", handlers.SetGroupRetention).Methods("PUT", "DELETE")
+	protected.HandleFunc("/groups/{id}/members", handlers.InviteGroupMember).Methods("POST")
+	protected.HandleFunc("/groups/{id}/members/{userId}", handlers.KickGroupMember).Methods("DELETE")
+	protected.HandleFunc("/groups/{id}/members/{userId}/role", handlers.SetGroupMemberRole).Methods("PUT")
+
+	protected.HandleFunc("/friends", handlers.GetFriends).Methods("GET")
+	protected.HandleFunc("/friends", handlers.AddFriend).Methods("POST")
+	protected.HandleFunc("/friends/requests", handlers.GetFriendRequests).Methods("GET")
+	protected.HandleFunc("/friends/requests/outgoing", handlers.GetOutgoingFriendRequests).Methods("GET")
+	protected.HandleFunc("/friends/{id}/accept", handlers.AcceptFriend).Methods("POST")
+	protected.HandleFunc("/friends/{id}/decline", handlers.DeclineFriend).Methods("POST")
+	protected.HandleFunc("/friends/{id}/cancel", handlers.CancelFriend).Methods("POST")
+	protected.HandleFunc("/friends/{id}", handlers.RemoveFriend).Methods("DELETE")
+
+	protected.HandleFunc("/blocks", handlers.GetBlockedUsers).Methods("GET")
+	protected.HandleFunc("/blocks/{id}", handlers.BlockUser).Methods("POST")
+	protected.HandleFunc("/blocks/{id}", handlers.UnblockUser).Methods("DELETE")
+
+	protected.HandleFunc("/users/search", handlers.SearchUsers).Methods("GET")
+	protected.HandleFunc("/search/messages", handlers.SearchMessages).Methods("GET")
+
+	protected.HandleFunc("/media", handlers.UploadMedia).Methods("POST")
+	protected.HandleFunc("/media/{id}", handlers.GetMedia).Methods("GET")
+
+	// WebSocket
+	r.Handle("/ws", middleware.Auth(http.HandlerFunc(handlers.HandleWebSocket)))
+
+	// Static files and HTML pages
+	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir("./static"))))
+	r.HandleFunc("/app", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "./static/app.html")
+	})
+	r.HandleFunc("/admin", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "./static/admin.html")
+	})
+	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "./static/index.html")
+	})
+
+	return r
+}
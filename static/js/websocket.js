@@ -8,11 +8,17 @@ class WebSocketClient {
         this.reconnectDelay = 1000;
         this.listeners = {};
         this.connected = false;
+        this.deviceId = null;
+        this.lastSeq = null; // Last event log position seen, replayed from on reconnect
     }
 
     connect() {
         const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
-        const wsUrl = `${protocol}//${window.location.host}/ws`;
+        const params = new URLSearchParams();
+        if (this.deviceId) params.set('device_id', this.deviceId);
+        if (this.lastSeq !== null) params.set('since', this.lastSeq);
+        const query = params.toString();
+        const wsUrl = `${protocol}//${window.location.host}/ws${query ? '?' + query : ''}`;
 
         try {
             this.ws = new WebSocket(wsUrl);
@@ -67,18 +73,41 @@ class WebSocketClient {
     }
 
     handleMessage(message) {
-        const { type, payload } = message;
+        const { type, payload, seq } = message;
+
+        if (seq) {
+            // Replays can overlap frames we already handled
+            if (this.lastSeq !== null && seq <= this.lastSeq) return;
+            this.lastSeq = seq;
+        }
 
         switch (type) {
+            case 'connected':
+                this.deviceId = payload.device_id;
+                if (this.lastSeq === null) this.lastSeq = payload.seq;
+                break;
+            case 'resync_required':
+                // Too much was missed to replay; reload state over REST
+                this.lastSeq = payload.seq;
+                this.emit('resync', payload);
+                break;
             case 'message':
+                // Let the sender know this device got it
+                this.send('delivered', { message_ids: [payload.id] });
                 this.emit('message', payload);
                 break;
             case 'typing':
                 this.emit('typing', payload);
                 break;
+            case 'delivered':
+                this.emit('delivered', payload);
+                break;
             case 'read':
                 this.emit('read', payload);
                 break;
+            case 'reaction':
+                this.emit('reaction', payload);
+                break;
             case 'online_status':
                 this.emit('online_status', payload);
                 break;